@@ -0,0 +1,221 @@
+package turbo
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// backendChecker is implemented by servers which are able to check the connectivity to backend services
+type backendChecker interface {
+	checkBackend(ctx context.Context) error
+}
+
+// readyCheckTimeout is the max time spent on checking backend connectivity in /readyz
+var readyCheckTimeout = time.Second * 3
+
+func (s *Server) setReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+// Ready returns true if the server is started and not stopping
+func (s *Server) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// startAdminServer starts an admin HTTP server at "admin_address", or "admin_port" of 127.0.0.1, it serves:
+// /healthz  returns 200 if the process is alive
+// /readyz   returns 200 if the server is ready and backends are reachable, 503 otherwise
+// /routes   returns the loaded url mappings and components
+// /config   returns the effective config
+// /reload   (POST) reloads the config file, 501 if the server only serves the backend service
+// /cache/invalidate (POST) removes cached responses whose paths start with "path" in query, all if it's empty
+// A grpc backend is checked in /readyz by the grpc health checking protocol, while a thrift backend is only checked
+// by opening a connection to it.
+func startAdminServer(s Servable) *http.Server {
+	c := s.ServerField().currentConfig()
+	addr := c.AdminAddress()
+	if addr == "" {
+		return nil
+	}
+	lis, err := listen(addr, c.UnixSocketMode())
+	if err != nil {
+		log.Errorf("Admin Server failed to listen: %v", err)
+		return nil
+	}
+	hs := &http.Server{Handler: adminRouter(s)}
+	go func() {
+		if err := hs.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Admin Server failed to serve: %v", err)
+		}
+	}()
+	log.Infof("Admin Server started at %s", addr)
+	return hs
+}
+
+func adminRouter(s Servable) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", healthzHandler).Methods("GET")
+	r.HandleFunc("/readyz", readyzHandler(s)).Methods("GET")
+	r.HandleFunc("/routes", routesHandler(s)).Methods("GET")
+	r.HandleFunc("/config", configHandler(s)).Methods("GET")
+	r.HandleFunc("/reload", reloadHandler(s)).Methods("POST")
+	r.HandleFunc("/cache/invalidate", invalidateCacheHandler(s)).Methods("POST")
+	return r
+}
+
+func healthzHandler(resp http.ResponseWriter, req *http.Request) {
+	resp.Write([]byte("ok"))
+}
+
+func readyzHandler(s Servable) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !s.ServerField().Ready() {
+			http.Error(resp, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		if checker, ok := s.(backendChecker); ok {
+			ctx, cancel := context.WithTimeout(req.Context(), readyCheckTimeout)
+			defer cancel()
+			if err := checker.checkBackend(ctx); err != nil {
+				http.Error(resp, "backend not ready: "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		resp.Write([]byte("ok"))
+	}
+}
+
+type routeInfo struct {
+	Methods string `json:"methods"`
+	Path    string `json:"path"`
+	Method  string `json:"method"`
+}
+
+type componentInfo struct {
+	Methods string `json:"methods,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Name    string `json:"name"`
+}
+
+func routesHandler(s Servable) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		c := s.ServerField().currentConfig()
+		routes := make([]routeInfo, 0)
+		for _, m := range c.mappings[urlServiceMaps] {
+			routes = append(routes, routeInfo{Methods: m[0], Path: m[1], Method: m[2]})
+		}
+		components := make(map[string][]componentInfo)
+		for _, kind := range []string{interceptors, preprocessors, postprocessors, hijackers} {
+			list := make([]componentInfo, 0)
+			for _, m := range c.mappings[kind] {
+				list = append(list, componentInfo{Methods: m[0], Path: m[1], Name: m[2]})
+			}
+			components[kind] = list
+		}
+		list := make([]componentInfo, 0)
+		for _, m := range c.mappings[convertors] {
+			list = append(list, componentInfo{Path: m[0], Name: m[1]})
+		}
+		components[convertors] = list
+		global := make([]componentInfo, 0)
+		for _, name := range c.GlobalInterceptors() {
+			global = append(global, componentInfo{Name: name})
+		}
+		components["globalInterceptors"] = global
+		registered := make([]string, 0)
+		for name := range s.ServerField().currentComponents().registeredComponents {
+			registered = append(registered, name)
+		}
+		sort.Strings(registered)
+		writeJSON(resp, map[string]interface{}{
+			"routes":               routes,
+			"components":           components,
+			"errorhandler":         c.ErrorHandler(),
+			"registeredComponents": registered,
+		})
+	}
+}
+
+func configHandler(s Servable) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		c := s.ServerField().currentConfig()
+		writeJSON(resp, map[string]interface{}{
+			"file":     c.File,
+			"rpcType":  c.RpcType(),
+			"config":   redactConfigs(c.configs),
+			"mappings": c.mappings,
+		})
+	}
+}
+
+// redacted replaces values of secrets in /config
+const redacted = "[REDACTED]"
+
+// redactConfigs returns a copy of configs, with values of secrets, e.g. "auth_jwt_hmac_secret", replaced,
+// a key is a secret if it contains "secret", "password" or "token".
+func redactConfigs(configs map[string]string) map[string]string {
+	result := make(map[string]string, len(configs))
+	for k, v := range configs {
+		if isSecretConfig(k) && len(v) > 0 {
+			v = redacted
+		}
+		result[k] = v
+	}
+	return result
+}
+
+func isSecretConfig(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range []string{"secret", "password", "token"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func reloadHandler(s Servable) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		log.Info("Reload triggered by admin server")
+		err := s.ServerField().ReloadConfig()
+		if err == errReloadNotSupported {
+			http.Error(resp, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp.WriteHeader(http.StatusAccepted)
+		resp.Write([]byte("reloading"))
+	}
+}
+
+func invalidateCacheHandler(s Servable) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		prefix := req.URL.Query().Get("path")
+		n := s.ServerField().InvalidateCache(prefix)
+		log.Infof("Cache invalidated by admin server, path: %q, removed: %d", prefix, n)
+		writeJSON(resp, map[string]interface{}{"path": prefix, "invalidated": n})
+	}
+}
+
+func writeJSON(resp http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(b)
+}
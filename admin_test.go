@@ -0,0 +1,111 @@
+package turbo
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func adminGet(t *testing.T, s Servable, url string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", url, nil)
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	adminRouter(s).ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminHealthz(t *testing.T) {
+	s := &GrpcServer{Server: &Server{Config: NewConfig("grpc", "test/service_test.yaml")}, gClient: new(grpcClient)}
+	w := adminGet(t, s, "/healthz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+}
+
+func TestAdminReadyz(t *testing.T) {
+	s := &GrpcServer{Server: &Server{Config: NewConfig("grpc", "test/service_test.yaml")}, gClient: new(grpcClient)}
+	w := adminGet(t, s, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "not ready\n", w.Body.String())
+
+	s.setReady(true)
+	w = adminGet(t, s, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "backend not ready: grpc client is not connected\n", w.Body.String())
+
+	c := NewConfigFromMap("grpc", map[string]string{httpPort: "0", grpcServiceName: "TestService",
+		grpcServiceHost: "127.0.0.1", grpcServicePort: "50099", grpcTransport: "inprocess"})
+	s = NewGrpcServerWithConfig(nil, c)
+	s.grpcServer = s.startGrpcServiceInternal(func(*grpc.Server) {}, false)
+	defer s.stopService(context.Background())
+	s.WithClient(func(conn *grpc.ClientConn) interface{} { return healthpb.NewHealthClient(conn) }, nil)
+	s.connectClient()
+	defer s.closeClient()
+	s.setReady(true)
+	w = adminGet(t, s, "/readyz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+}
+
+func TestAdminReload(t *testing.T) {
+	s := NewGrpcServerWithConfig(nil, NewConfig("grpc", "test/service_test.yaml"))
+	req, _ := http.NewRequest("POST", "/reload", nil)
+	w := httptest.NewRecorder()
+	adminRouter(s).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// a service-only server doesn't reload its config
+	s.reloadConfig = nil
+	w = httptest.NewRecorder()
+	adminRouter(s).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	assert.Equal(t, errReloadNotSupported.Error()+"\n", w.Body.String())
+}
+
+func TestAdminAddress(t *testing.T) {
+	c := NewConfigFromMap("grpc", map[string]string{httpPort: "0"})
+	assert.Equal(t, "", c.AdminAddress())
+	c.configs[adminPort] = "9090"
+	assert.Equal(t, "127.0.0.1:9090", c.AdminAddress())
+	c.configs[adminAddress] = "unix:///tmp/turbo-admin.sock"
+	assert.Equal(t, "unix:///tmp/turbo-admin.sock", c.AdminAddress())
+}
+
+func TestAdminRoutesAndConfig(t *testing.T) {
+	s := &GrpcServer{Server: &Server{Config: NewConfig("grpc", "test/service_test.yaml"), Components: new(Components)}}
+	s.RegisterComponent("LogInterceptor", &BaseInterceptor{})
+	w := adminGet(t, s, "/routes")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var routes struct {
+		Routes               []routeInfo
+		Components           map[string][]componentInfo
+		RegisteredComponents []string
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &routes))
+	assert.Equal(t, routeInfo{Methods: "GET,POST", Path: "/hello", Method: "SayHello"}, routes.Routes[0])
+	assert.Equal(t, "LogInterceptor", routes.Components[interceptors][0].Name)
+	assert.Equal(t, componentInfo{Path: "CommonValues", Name: "convertor"}, routes.Components[convertors][0])
+	assert.Equal(t, []string{"LogInterceptor"}, routes.RegisteredComponents)
+
+	s.Config.configs[authJWTHMACSecret] = "secret"
+	s.Config.configs["db_password"] = "password"
+	w = adminGet(t, s, "/config")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), `"secret"`)
+	assert.NotContains(t, w.Body.String(), `"password"`)
+	var config struct {
+		File   string
+		Config map[string]string
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &config))
+	assert.Equal(t, "test/service_test.yaml", config.File)
+	assert.Equal(t, "8081", config.Config[httpPort])
+	assert.Equal(t, redacted, config.Config[authJWTHMACSecret])
+	assert.Equal(t, redacted, config.Config["db_password"])
+	assert.Equal(t, "secret", s.Config.configs[authJWTHMACSecret])
+}
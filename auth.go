@@ -0,0 +1,286 @@
+package turbo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// names of built-in authentication Interceptors, they are available in config without registering, e.g.
+//
+//	interceptor:
+//	  - GET,POST /api/ JWTAuth
+//	  - GET /admin/ BasicAuth
+//
+// a component registered with the same name takes precedence.
+const (
+	JWTAuthName    = "JWTAuth"
+	APIKeyAuthName = "APIKeyAuth"
+	BasicAuthName  = "BasicAuth"
+)
+
+// AuthInfo is the verified identity of a request
+type AuthInfo struct {
+	// Scheme is "jwt", "apikey" or "basic"
+	Scheme string
+	// Subject is "sub" of a JWT, the name of an API key, or the user name of basic auth
+	Subject string
+	// Claims are the claims of a JWT, it has only "sub" for API keys and basic auth
+	Claims map[string]interface{}
+}
+
+type authKey struct{}
+
+// authFieldsKey is the context key of claims mapped to request fields, keyed by field names
+type authFieldsKey struct{}
+
+// Auth returns the identity verified by built-in authentication Interceptors, nil if not authenticated
+func Auth(req *http.Request) *AuthInfo {
+	info, _ := req.Context().Value(authKey{}).(*AuthInfo)
+	return info
+}
+
+// setAuth saves info to the request context, and claims in fields to context with field names,
+// so that they are set to request fields, in precedence over values in the request.
+func setAuth(req *http.Request, info *AuthInfo, fields map[string]string) {
+	ctx := context.WithValue(req.Context(), authKey{}, info)
+	values := make(map[string]string)
+	for claim, field := range fields {
+		if v, ok := info.Claims[claim]; ok {
+			values[field] = fmt.Sprint(v)
+			ctx = context.WithValue(ctx, field, fmt.Sprint(v))
+		}
+	}
+	ctx = context.WithValue(ctx, authFieldsKey{}, values)
+	*req = *req.WithContext(ctx)
+}
+
+func authFields(req *http.Request) map[string]string {
+	values, _ := req.Context().Value(authFieldsKey{}).(map[string]string)
+	return values
+}
+
+// findAuthValue returns the claim mapped to fieldName, field names in "auth_claim_fields" match fieldName
+// as it is, in lower case, or in snake case.
+func findAuthValue(fieldName string, req *http.Request) (string, bool) {
+	values := authFields(req)
+	if len(values) == 0 {
+		return "", false
+	}
+	for _, name := range []string{fieldName, strings.ToLower(fieldName), ToSnakeCase(fieldName)} {
+		if v, ok := values[name]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func unauthorized(challenge, message string) error {
+	return &HTTPError{Status: http.StatusUnauthorized, Message: "turbo: unauthorized, " + message,
+		Header: http.Header{"Www-Authenticate": {challenge}}}
+}
+
+// JWTAuth is an Interceptor which verifies the JWT in "Authorization: Bearer" header
+type JWTAuth struct {
+	BaseInterceptor
+	keys     *jwtKeySet
+	issuer   string
+	audience string
+	fields   map[string]string
+}
+
+func (a *JWTAuth) Before(resp http.ResponseWriter, req *http.Request) error {
+	auth := req.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return unauthorized("Bearer", "missing bearer token")
+	}
+	claims, err := verifyJWT(strings.TrimSpace(auth[7:]), a.keys, time.Now())
+	if err != nil {
+		return unauthorized(`Bearer error="invalid_token"`, err.Error())
+	}
+	if len(a.issuer) > 0 && claims["iss"] != a.issuer {
+		return unauthorized(`Bearer error="invalid_token"`, "invalid issuer")
+	}
+	if len(a.audience) > 0 && !hasAudience(claims["aud"], a.audience) {
+		return unauthorized(`Bearer error="invalid_token"`, "invalid audience")
+	}
+	sub, _ := claims["sub"].(string)
+	setAuth(req, &AuthInfo{Scheme: "jwt", Subject: sub, Claims: claims}, a.fields)
+	return nil
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// APIKeyAuth is an Interceptor which looks up the API key in a header
+type APIKeyAuth struct {
+	BaseInterceptor
+	header string
+	// names maps API keys to their names
+	names  map[string]string
+	fields map[string]string
+}
+
+func (a *APIKeyAuth) Before(resp http.ResponseWriter, req *http.Request) error {
+	key := req.Header.Get(a.header)
+	if len(key) == 0 {
+		return unauthorized("ApiKey", "missing header "+a.header)
+	}
+	name, ok := a.names[key]
+	if !ok {
+		return unauthorized("ApiKey", "invalid API key")
+	}
+	setAuth(req, &AuthInfo{Scheme: "apikey", Subject: name, Claims: map[string]interface{}{"sub": name}}, a.fields)
+	return nil
+}
+
+// BasicAuth is an Interceptor which checks the user name and password of basic auth
+type BasicAuth struct {
+	BaseInterceptor
+	realm string
+	// passwords maps user names to passwords, which are either plain text, or "sha256:[hex digest]"
+	passwords map[string]string
+	fields    map[string]string
+}
+
+func (a *BasicAuth) Before(resp http.ResponseWriter, req *http.Request) error {
+	challenge := `Basic realm="` + a.realm + `"`
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return unauthorized(challenge, "missing basic auth")
+	}
+	expected, found := a.passwords[user]
+	if strings.HasPrefix(expected, "sha256:") {
+		sum := sha256.Sum256([]byte(password))
+		password, expected = hex.EncodeToString(sum[:]), strings.ToLower(expected[len("sha256:"):])
+	}
+	if !found || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+		return unauthorized(challenge, "invalid user name or password")
+	}
+	setAuth(req, &AuthInfo{Scheme: "basic", Subject: user, Claims: map[string]interface{}{"sub": user}}, a.fields)
+	return nil
+}
+
+// authComponents returns built-in authentication Interceptors which are set up in config
+func authComponents(c *Config) map[string]interface{} {
+	components := make(map[string]interface{})
+	fields := c.AuthClaimFields()
+	if keys := c.jwtKeys(); keys != nil {
+		components[JWTAuthName] = &JWTAuth{keys: keys, issuer: c.configs[authJWTIssuer],
+			audience: c.configs[authJWTAudience], fields: fields}
+	}
+	if file := c.configs[authAPIKeyFile]; len(file) > 0 {
+		header := c.configs[authAPIKeyHeader]
+		if len(header) == 0 {
+			header = "X-Api-Key"
+		}
+		names := make(map[string]string)
+		for _, f := range readCredentialLines(c.relativePath(file), " ") {
+			names[f[1]] = f[0]
+		}
+		components[APIKeyAuthName] = &APIKeyAuth{header: header, names: names, fields: fields}
+	}
+	if file := c.configs[authBasicFile]; len(file) > 0 {
+		realm := c.configs[authBasicRealm]
+		if len(realm) == 0 {
+			realm = "turbo"
+		}
+		passwords := make(map[string]string)
+		for _, f := range readCredentialLines(c.relativePath(file), ":") {
+			passwords[f[0]] = f[1]
+		}
+		components[BasicAuthName] = &BasicAuth{realm: realm, passwords: passwords, fields: fields}
+	}
+	return components
+}
+
+// jwtKeys returns keys in "auth_jwt_jwks_file", "auth_jwt_public_key_file" and "auth_jwt_hmac_secret",
+// nil if none of them is set.
+func (c *Config) jwtKeys() *jwtKeySet {
+	keys := new(jwtKeySet)
+	if file := c.configs[authJWTJWKSFile]; len(file) > 0 {
+		if err := loadJWKS(c.relativePath(file), keys); err != nil {
+			panic("[" + authJWTJWKSFile + "] " + err.Error())
+		}
+	}
+	if file := c.configs[authJWTPublicKeyFile]; len(file) > 0 {
+		key, err := loadPublicKey(c.relativePath(file))
+		if err != nil {
+			panic("[" + authJWTPublicKeyFile + "] " + err.Error())
+		}
+		keys.add("", key)
+	}
+	if secret := c.configs[authJWTHMACSecret]; len(secret) > 0 {
+		keys.add("", []byte(secret))
+	}
+	if len(keys.keys) == 0 {
+		return nil
+	}
+	return keys
+}
+
+// AuthClaimFields returns "auth_claim_fields" in config file, e.g. "sub:user_id,tenant:tenant_id",
+// a verified claim is saved to the request context with the field name, and set to the request field.
+func (c *Config) AuthClaimFields() map[string]string {
+	fields := make(map[string]string)
+	names, _ := parseNames(c.configs[authClaimFields])
+	for _, pair := range names {
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 || len(strings.TrimSpace(kv[1])) == 0 {
+			panic("[" + authClaimFields + "] should be like \"sub:user_id,tenant:tenant_id\", got: " + pair)
+		}
+		fields[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return fields
+}
+
+// relativePath returns file relative to the directory of the config file, if it's not absolute
+func (c *Config) relativePath(file string) string {
+	if strings.HasPrefix(file, "/") || c.Source != nil {
+		return file
+	}
+	if i := strings.LastIndex(c.File, "/"); i >= 0 {
+		return c.File[:i+1] + file
+	}
+	return file
+}
+
+// readCredentialLines reads lines split by sep into 2 parts in a credential file,
+// empty lines and lines start with "#" are ignored.
+func readCredentialLines(file, sep string) [][2]string {
+	content, err := ioutil.ReadFile(file)
+	panicIf(err)
+	result := make([][2]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, sep)
+		if i <= 0 || i == len(line)-1 {
+			panic(fmt.Sprintf("%s:%d: invalid line, should be like \"NAME%sVALUE\"", file, n, sep))
+		}
+		result = append(result, [2]string{strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])})
+	}
+	return result
+}
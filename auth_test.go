@@ -0,0 +1,188 @@
+package turbo
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		assert.Nil(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		assert.Nil(t, err)
+		sig = append(padded(r, 32), padded(s, 32)...)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func padded(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	dir, err := ioutil.TempDir("", "turbo_auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{"kty": "RSA", "kid": "k1", "use": "sig",
+		"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())}}})
+	assert.Nil(t, ioutil.WriteFile(dir+"/jwks.json", jwks, 0644))
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	assert.Nil(t, ioutil.WriteFile(dir+"/ec.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+
+	keys := new(jwtKeySet)
+	assert.Nil(t, loadJWKS(dir+"/jwks.json", keys))
+	key, err := loadPublicKey(dir + "/ec.pem")
+	assert.Nil(t, err)
+	keys.add("", key)
+	keys.add("", []byte("secret"))
+
+	now := time.Now()
+	claims := map[string]interface{}{"sub": "alice", "exp": now.Add(time.Minute).Unix()}
+	for _, token := range []string{signJWT(t, "RS256", "k1", rsaKey, claims), signJWT(t, "ES256", "", ecKey, claims),
+		signJWT(t, "HS256", "", []byte("secret"), claims)} {
+		verified, err := verifyJWT(token, keys, now)
+		assert.Nil(t, err)
+		assert.Equal(t, "alice", verified["sub"])
+	}
+
+	_, err = verifyJWT(signJWT(t, "HS256", "", []byte("wrong"), claims), keys, now)
+	assert.Equal(t, "invalid signature", err.Error())
+	_, err = verifyJWT(signJWT(t, "HS256", "k1", []byte("secret"), claims), keys, now)
+	assert.Equal(t, "alg HS256 doesn't match an RSA key", err.Error())
+	_, err = verifyJWT(signJWT(t, "none", "", []byte("secret"), claims), keys, now)
+	assert.Equal(t, "unsupported alg: none", err.Error())
+	_, err = verifyJWT(signJWT(t, "HS256", "", []byte("secret"), claims), keys, now.Add(time.Hour))
+	assert.Equal(t, "token is expired", err.Error())
+	_, err = verifyJWT("a.b", keys, now)
+	assert.Equal(t, "malformed token", err.Error())
+}
+
+func TestAuthInterceptors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "turbo_auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(dir+"/apikeys", []byte("# name key\nbilling k-123\n"), 0644))
+	sum := sha256.Sum256([]byte("pass"))
+	assert.Nil(t, ioutil.WriteFile(dir+"/users", []byte(fmt.Sprintf("alice:sha256:%x\nbob:plain\n", sum)), 0644))
+
+	s := setupRoutedServer(t, `config:
+  http_port: 8081
+  auth_jwt_hmac_secret: secret
+  auth_jwt_issuer: turbo
+  auth_api_key_file: `+dir+`/apikeys
+  auth_basic_file: `+dir+`/users
+  auth_claim_fields: sub:your_name,tenant:tenant_id,iss:message
+urlmapping:
+  - GET /jwt SayHello
+  - POST /json SayHello
+  - GET /key SayHello
+  - GET /basic SayHello
+hijacker:
+  - GET /jwt hijacker
+  - POST /json json
+  - GET /key hijacker
+  - GET /basic hijacker
+interceptor:
+  - GET /jwt JWTAuth
+  - POST /json JWTAuth
+  - GET /key APIKeyAuth
+  - GET /basic BasicAuth
+`, func(s *Server) {
+		s.RegisterHijacker("hijacker", func(resp http.ResponseWriter, req *http.Request) {
+			name, _ := findValue("YourName", req)
+			tenant, _ := findValue("TenantId", req)
+			resp.Write([]byte(Auth(req).Scheme + ":" + name + ":" + tenant))
+		})
+		s.RegisterHijacker("json", func(resp http.ResponseWriter, req *http.Request) {
+			m := &testMessage{}
+			assert.Nil(t, BuildRequest(nil, m, req))
+			resp.Write([]byte(m.Message))
+		})
+	})
+
+	token := signJWT(t, "HS256", "", []byte("secret"),
+		map[string]interface{}{"sub": "alice", "iss": "turbo", "tenant": 42, "exp": time.Now().Add(time.Minute).Unix()})
+	w := routedRequest(s, "GET", "/jwt", map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, "jwt:alice:42", w.Body.String())
+	// claims can't be spoofed by parameters, or by a JSON body
+	w = routedRequest(s, "GET", "/jwt?your_name=bob&yourname=bob&tenant_id=1", map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, "jwt:alice:42", w.Body.String())
+	req, _ := http.NewRequest("POST", "/json", strings.NewReader(`{"message":"spoofed"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	assert.Equal(t, "turbo", w.Body.String())
+	w = routedRequest(s, "GET", "/jwt", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	token = signJWT(t, "HS256", "", []byte("secret"), map[string]interface{}{"sub": "alice", "iss": "other"})
+	w = routedRequest(s, "GET", "/jwt", map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, "turbo: unauthorized, invalid issuer\n", w.Body.String())
+
+	w = routedRequest(s, "GET", "/key", map[string]string{"X-Api-Key": "k-123"})
+	assert.Equal(t, "apikey:billing:", w.Body.String())
+	w = routedRequest(s, "GET", "/key", map[string]string{"X-Api-Key": "k-456"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req, _ = http.NewRequest("GET", "/basic", nil)
+	req.SetBasicAuth("alice", "pass")
+	w = httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	assert.Equal(t, "basic:alice:", w.Body.String())
+	req.SetBasicAuth("bob", "plain")
+	w = httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	assert.Equal(t, "basic:bob:", w.Body.String())
+	req.SetBasicAuth("alice", "wrong")
+	w = httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="turbo"`, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestAuthNotConfigured(t *testing.T) {
+	c := NewConfigFromMap("grpc", map[string]string{httpPort: "8081"})
+	assert.Equal(t, map[string]interface{}{}, authComponents(c))
+	defer func() {
+		assert.Equal(t, `[auth_claim_fields] should be like "sub:user_id,tenant:tenant_id", got: sub`, recover())
+	}()
+	NewConfigFromMap("grpc", map[string]string{httpPort: "8081", authClaimFields: "sub"})
+}
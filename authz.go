@@ -0,0 +1,260 @@
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/spf13/cast"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const authzKey = "authorization"
+
+// AuthzRule is an entry in "authorization", it's keyed by an URL pattern, or a method name, e.g.
+//
+//	authorization:
+//	  - path: /admin/
+//	    roles: [admin, ops]
+//	  - rpc: DeleteUser
+//	    methods: POST
+//	    scopes: [user:write]
+//	    claims:
+//	      tenant: [acme]
+//	    deny:
+//	      sub: [mallory]
+//	    policy: ownerOnly
+//
+// all rules matching a request are evaluated after Interceptors, which authenticate the request,
+// and before the request is sent to the backend, the request is forbidden if any of them fails.
+type AuthzRule struct {
+	// Methods are the HTTP methods the rule applies to, empty means all methods
+	Methods []string
+	// Path is an URL pattern, a pattern ending with "/" is a group, which matches all URLs with this prefix
+	Path string
+	// RPC is a method name, it's used if Path is empty
+	RPC string
+	// Scopes are all required, they are in the "scope" claim separated by spaces, or in the "scp" claim
+	Scopes []string
+	// Roles requires any one of them in the "roles" claim
+	Roles []string
+	// Claims requires each claim to match one of the values
+	Claims map[string][]string
+	// Deny forbids requests with any claim matching one of the values, e.g. {sub: [mallory]}
+	Deny map[string][]string
+	// Policy is the name of a registered AuthzPolicy
+	Policy string
+}
+
+// AuthzPolicy decides whether a request is allowed, info is nil if the request is not authenticated.
+// An error denies the request, it's responded as is if it's an *HTTPError, or with 403.
+type AuthzPolicy interface {
+	Authorize(req *http.Request, info *AuthInfo) error
+}
+
+// AuthzPolicyFunc is a func which implements AuthzPolicy
+type AuthzPolicyFunc func(req *http.Request, info *AuthInfo) error
+
+// Authorize calls f(req, info)
+func (f AuthzPolicyFunc) Authorize(req *http.Request, info *AuthInfo) error {
+	return f(req, info)
+}
+
+var authzFields = []string{"path", "rpc", "methods", "scopes", "roles", "claims", "deny", "policy"}
+
+// parseAuthzRule parses a map with keys in fields
+func parseAuthzRule(m map[string]interface{}, fields []string) (r AuthzRule, err error) {
+	if err = checkKeys(m, fields); err != nil {
+		return r, err
+	}
+	r.Path = strings.TrimSpace(cast.ToString(m["path"]))
+	r.RPC = strings.TrimSpace(cast.ToString(m["rpc"]))
+	r.Policy = strings.TrimSpace(cast.ToString(m["policy"]))
+	lists := map[string]*[]string{"methods": &r.Methods, "scopes": &r.Scopes, "roles": &r.Roles}
+	for key, list := range lists {
+		if *list, err = parseNames(m[key]); err != nil {
+			return r, fmt.Errorf("[%s] should be a list of names, or names separated by ','", key)
+		}
+	}
+	for i, method := range r.Methods {
+		r.Methods[i] = strings.ToUpper(method)
+	}
+	matchers := map[string]*map[string][]string{"claims": &r.Claims, "deny": &r.Deny}
+	for key, matcher := range matchers {
+		if *matcher, err = parseClaimMatcher(m[key]); err != nil {
+			return r, fmt.Errorf("[%s] should be a map of claims to values, e.g. {tenant: [a, b]}", key)
+		}
+	}
+	if len(r.Scopes) == 0 && len(r.Roles) == 0 && len(r.Claims) == 0 && len(r.Deny) == 0 && len(r.Policy) == 0 {
+		return r, errors.New("one of [scopes], [roles], [claims], [deny] or [policy] is required")
+	}
+	return r, nil
+}
+
+// parseClaimMatcher parses a map like {tenant: [a, b], level: gold}
+func parseClaimMatcher(v interface{}) (map[string][]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	m, err := cast.ToStringMapE(v)
+	if err != nil {
+		return nil, err
+	}
+	matcher := make(map[string][]string)
+	for claim, values := range m {
+		if matcher[claim], err = parseNames(values); err != nil {
+			return nil, err
+		}
+	}
+	return matcher, nil
+}
+
+// loadAuthorization loads rules in "authorization"
+func (c *Config) loadAuthorization() {
+	raw := c.Get(authzKey)
+	if raw == nil {
+		return
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		panic(fmt.Sprintf("invalid [%s], should be a list, got: %v", authzKey, raw))
+	}
+	for i, item := range list {
+		m, err := cast.ToStringMapE(item)
+		var r AuthzRule
+		if err == nil {
+			r, err = parseAuthzRule(m, authzFields)
+		}
+		if err == nil && len(r.Path) > 0 == (len(r.RPC) > 0) {
+			err = errors.New("exactly one of [path] and [rpc] is required")
+		}
+		if err == nil && len(r.Path) > 0 && !strings.HasPrefix(r.Path, "/") {
+			err = errors.New("[path] should start with '/', got: " + r.Path)
+		}
+		if err != nil {
+			text := "path: " + r.Path
+			if len(r.Path) == 0 {
+				text = "rpc: " + r.RPC
+			}
+			panic(fmt.Sprintf("%s: invalid [%s] #%d, %s", c.position(authzKey, i, text), authzKey, i+1, err))
+		}
+		c.authz = append(c.authz, r)
+	}
+}
+
+// authzRule is an AuthzRule with its matcher and policy resolved
+type authzRule struct {
+	AuthzRule
+	// route matches requests if Path is set
+	route  *mux.Route
+	policy AuthzPolicy
+}
+
+func (c *Components) addAuthzRule(rule AuthzRule, policy AuthzPolicy) {
+	r := authzRule{AuthzRule: rule, policy: policy}
+	if len(rule.Path) > 0 {
+		r.route = mux.NewRouter().NewRoute()
+		if strings.HasSuffix(rule.Path, "/") {
+			r.route.PathPrefix(rule.Path)
+		} else {
+			r.route.Path(rule.Path)
+		}
+	}
+	c.authzRules = append(c.authzRules, r)
+}
+
+// checkAuthorization evaluates rules matching req, or methodName, in order,
+// it returns the error of the first rule which denies the request.
+func (c *Components) checkAuthorization(req *http.Request, methodName string) error {
+	for _, r := range c.authzRules {
+		if len(r.Methods) > 0 && !contains(r.Methods, req.Method) {
+			continue
+		}
+		if r.route != nil && !r.route.Match(req, &mux.RouteMatch{}) || r.route == nil && r.RPC != methodName {
+			continue
+		}
+		if err := r.check(req, Auth(req)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forbidden(message string) error {
+	return &HTTPError{Status: http.StatusForbidden, Message: "turbo: forbidden, " + message}
+}
+
+// check returns an error if info doesn't satisfy r
+func (r *authzRule) check(req *http.Request, info *AuthInfo) error {
+	if len(r.Scopes) > 0 || len(r.Roles) > 0 || len(r.Claims) > 0 || len(r.Deny) > 0 {
+		if info == nil {
+			return &HTTPError{Status: http.StatusUnauthorized, Message: "turbo: unauthorized, not authenticated"}
+		}
+		for _, claim := range sortedKeys(r.Deny) {
+			if matchClaim(info.Claims[claim], r.Deny[claim]) {
+				return forbidden("denied by claim " + claim)
+			}
+		}
+		scopes := claimValues(info.Claims["scp"])
+		for _, s := range claimValues(info.Claims["scope"]) {
+			scopes = append(scopes, strings.Fields(s)...)
+		}
+		for _, scope := range r.Scopes {
+			if !contains(scopes, scope) {
+				return forbidden("missing scope " + scope)
+			}
+		}
+		if len(r.Roles) > 0 && !matchClaim(info.Claims["roles"], r.Roles) {
+			return forbidden("requires one of roles " + strings.Join(r.Roles, ", "))
+		}
+		for _, claim := range sortedKeys(r.Claims) {
+			if !matchClaim(info.Claims[claim], r.Claims[claim]) {
+				return forbidden("claim " + claim + " doesn't match")
+			}
+		}
+	}
+	if r.policy != nil {
+		if err := r.policy.Authorize(req, info); err != nil {
+			if _, ok := err.(*HTTPError); ok {
+				return err
+			}
+			return forbidden(err.Error())
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// claimValues returns a claim as strings, a list claim returns all its elements
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			values = append(values, fmt.Sprint(e))
+		}
+		return values
+	}
+	return []string{fmt.Sprint(claim)}
+}
+
+// matchClaim returns true if the claim, or any element of a list claim, is one of values
+func matchClaim(claim interface{}, values []string) bool {
+	for _, v := range claimValues(claim) {
+		if contains(values, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package turbo
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAuthorization(t *testing.T) {
+	open := true
+	s := setupRoutedServer(t, `config:
+  http_port: 8081
+  auth_jwt_hmac_secret: secret
+urlmapping:
+  - GET /admin/users SayHello
+  - GET,POST /orders SayHello
+  - GET /public SayHello
+hijacker:
+  - GET /admin/users hijacker
+  - GET,POST /orders hijacker
+  - GET /public hijacker
+global_interceptor: JWTAuth
+interceptor:
+  - GET /public !JWTAuth
+authorization:
+  - path: /admin/
+    roles: [admin, ops]
+    deny:
+      sub: [mallory]
+  - rpc: SayHello
+    methods: POST
+    scopes: order:write
+    claims:
+      tenant: [acme]
+    policy: workingHours
+  - path: /public
+    policy: workingHours
+`, func(s *Server) {
+		s.RegisterAuthzPolicy("workingHours", AuthzPolicyFunc(func(req *http.Request, info *AuthInfo) error {
+			if !open {
+				return errors.New("closed")
+			}
+			return nil
+		}))
+	})
+	bearer := func(claims map[string]interface{}) map[string]string {
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		return map[string]string{"Authorization": "Bearer " + signJWT(t, "HS256", "", []byte("secret"), claims)}
+	}
+
+	w := routedRequest(s, "GET", "/admin/users", bearer(map[string]interface{}{"sub": "alice", "roles": []string{"ops"}}))
+	assert.Equal(t, "hijacked", w.Body.String())
+	w = routedRequest(s, "GET", "/admin/users", bearer(map[string]interface{}{"sub": "bob", "roles": "user"}))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "turbo: forbidden, requires one of roles admin, ops\n", w.Body.String())
+	w = routedRequest(s, "GET", "/admin/users", bearer(map[string]interface{}{"sub": "mallory", "roles": []string{"admin"}}))
+	assert.Equal(t, "turbo: forbidden, denied by claim sub\n", w.Body.String())
+
+	w = routedRequest(s, "GET", "/orders", bearer(map[string]interface{}{"sub": "alice"}))
+	assert.Equal(t, "hijacked", w.Body.String(), "the rule of SayHello applies to POST only")
+	claims := map[string]interface{}{"sub": "alice", "scope": "order:read order:write", "tenant": "acme"}
+	w = routedRequest(s, "POST", "/orders", bearer(claims))
+	assert.Equal(t, "hijacked", w.Body.String())
+	w = routedRequest(s, "POST", "/orders", bearer(map[string]interface{}{"sub": "alice", "scp": []string{"order:read"}}))
+	assert.Equal(t, "turbo: forbidden, missing scope order:write\n", w.Body.String())
+	w = routedRequest(s, "POST", "/orders", bearer(map[string]interface{}{"sub": "alice", "scope": "order:write", "tenant": "other"}))
+	assert.Equal(t, "turbo: forbidden, claim tenant doesn't match\n", w.Body.String())
+	open = false
+	w = routedRequest(s, "POST", "/orders", bearer(claims))
+	assert.Equal(t, "turbo: forbidden, closed\n", w.Body.String())
+
+	w = routedRequest(s, "GET", "/public", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "policies run without authentication")
+	open = true
+	w = routedRequest(s, "GET", "/public", nil)
+	assert.Equal(t, "hijacked", w.Body.String())
+}
+
+func TestAuthorizationNotAuthenticated(t *testing.T) {
+	c := new(Components)
+	c.AddAuthzRule(AuthzRule{Path: "/admin/", Roles: []string{"admin"}}, nil)
+	req, _ := http.NewRequest("GET", "/admin/users", nil)
+	assert.Equal(t, &HTTPError{Status: http.StatusUnauthorized, Message: "turbo: unauthorized, not authenticated"},
+		c.checkAuthorization(req, "GetUser"))
+	req, _ = http.NewRequest("GET", "/users", nil)
+	assert.Nil(t, c.checkAuthorization(req, "GetUser"))
+}
+
+func TestAuthorizationConfig(t *testing.T) {
+	file, remove := writeTestConfig(t, `urlmapping:
+  - GET /hello SayHello
+routes:
+  - method: GET,PUT
+    path: /user
+    rpc: GetUser
+    authorization:
+      roles: admin
+      claims:
+        tenant: a,b
+`)
+	defer remove()
+	c := NewConfig("grpc", file)
+	assert.Equal(t, []AuthzRule{{Methods: []string{"GET", "PUT"}, Path: "/user", Roles: []string{"admin"},
+		Claims: map[string][]string{"tenant": {"a", "b"}}}}, c.authz)
+
+	invalid, remove := writeTestConfig(t, `urlmapping:
+  - GET /hello SayHello
+authorization:
+  - path: /hello
+    rpc: SayHello
+    roles: [admin]
+`)
+	defer remove()
+	defer func() {
+		assert.Equal(t, invalid+":4: invalid [authorization] #1, exactly one of [path] and [rpc] is required", recover())
+	}()
+	NewConfig("grpc", invalid)
+}
@@ -0,0 +1,309 @@
+package turbo
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheSize is the max number of entries in the default LRU cache, if "cache_size" is not set
+const defaultCacheSize = 1000
+
+// CachePolicy is the response cache setting of a GET route
+type CachePolicy struct {
+	// TTL is how long a response is cached
+	TTL time.Duration
+	// Headers are request headers which are part of the cache key, e.g. "Accept-Language"
+	Headers []string
+}
+
+// CachedResponse is a response stored in CacheStore
+type CachedResponse struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	ETag    string
+	Created time.Time
+	Expires time.Time
+}
+
+func (r *CachedResponse) expired(now time.Time) bool {
+	return !now.Before(r.Expires)
+}
+
+// CacheStore stores cached responses, e.g. in memory or in redis,
+// keys start with the request path, so that entries of a path can be invalidated by prefix.
+type CacheStore interface {
+	// Get returns the response stored with key, expired responses can be returned, they are ignored
+	Get(key string) (*CachedResponse, bool)
+	// Set stores r with key, r can be dropped after r.Expires
+	Set(key string, r *CachedResponse)
+	// Invalidate removes entries whose keys start with prefix, all entries are removed if prefix is empty,
+	// it returns the number of entries removed.
+	Invalidate(prefix string) int
+}
+
+// LRUCache is an in-memory CacheStore, the least recently used entry is evicted when it's full
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key      string
+	response *CachedResponse
+}
+
+// NewLRUCache returns a LRUCache which holds at most capacity entries
+func NewLRUCache(capacity int) *LRUCache {
+	if capacity <= 0 {
+		capacity = defaultCacheSize
+	}
+	return &LRUCache{capacity: capacity, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *LRUCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	r := e.Value.(*lruEntry).response
+	if r.expired(time.Now()) {
+		c.remove(e)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return r, true
+}
+
+func (c *LRUCache) Set(key string, r *CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruEntry).response = r
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, response: r})
+	for c.ll.Len() > c.capacity {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *LRUCache) Invalidate(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key, e := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(e)
+			n++
+		}
+	}
+	return n
+}
+
+// Len returns the number of entries in cache
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRUCache) remove(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.items, e.Value.(*lruEntry).key)
+}
+
+// SetCacheStore replaces the default in-memory LRU cache, it should be called before the server starts
+func (s *Server) SetCacheStore(store CacheStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cacheStore = store
+}
+
+// cache returns the CacheStore, a LRUCache with "cache_size" entries is created if it's not set
+func (s *Server) cache() CacheStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cacheStore == nil {
+		// s.mu is held, s.Config is read directly
+		s.cacheStore = NewLRUCache(int(s.Config.CacheSize()))
+	}
+	return s.cacheStore
+}
+
+// InvalidateCache removes cached responses whose paths start with prefix, all responses if prefix is empty,
+// it returns the number of responses removed.
+func (s *Server) InvalidateCache(prefix string) int {
+	return s.cache().Invalidate(prefix)
+}
+
+// cacheKey returns a key made of the path, the sorted query, and values of headers in policy
+func cacheKey(req *http.Request, p CachePolicy) string {
+	var b bytes.Buffer
+	b.WriteString(req.URL.Path)
+	if len(req.URL.RawQuery) > 0 {
+		b.WriteString("?" + req.URL.Query().Encode())
+	}
+	headers := append([]string(nil), p.Headers...)
+	sort.Strings(headers)
+	for _, h := range headers {
+		b.WriteString("\n" + http.CanonicalHeaderKey(h) + ": " + strings.Join(req.Header[http.CanonicalHeaderKey(h)], ","))
+	}
+	return b.String()
+}
+
+// cacheControl returns the directives in "Cache-Control" header, e.g. {"max-age": "0", "no-cache": ""}
+func cacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, v := range h["Cache-Control"] {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.ToLower(strings.TrimSpace(d))
+			if len(d) == 0 {
+				continue
+			}
+			if i := strings.Index(d, "="); i > 0 {
+				directives[d[:i]] = strings.Trim(d[i+1:], `"`)
+			} else {
+				directives[d] = ""
+			}
+		}
+	}
+	return directives
+}
+
+// addVary adds headers to the "Vary" header of h, headers already in it are not added again
+func addVary(h http.Header, headers []string) {
+	varied := make(map[string]bool)
+	for _, v := range h["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			varied[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	for _, name := range headers {
+		name = http.CanonicalHeaderKey(name)
+		if !varied[name] {
+			varied[name] = true
+			h.Add("Vary", name)
+		}
+	}
+}
+
+// etagMatches returns true if etag is in "If-None-Match" of req
+func etagMatches(req *http.Request, etag string) bool {
+	for _, v := range strings.Split(req.Header.Get("If-None-Match"), ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedResponse holds a response in memory, so that it can be cached before it's written
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// withCache serves GET requests from store, responses with status 200 are cached for p.TTL,
+// unless the request has "Cache-Control: no-store", or the response has "Cache-Control: no-store" or "private",
+// or sets a cookie. Responses vary on the headers in p.Headers, which are in the cache key.
+// Authenticated requests, and requests with an "Authorization" header, are neither served from nor stored in
+// the cache, since cache keys don't contain the identity.
+// Responses have an "ETag", and "If-None-Match" is answered with 304 if it matches.
+func withCache(h func(http.ResponseWriter, *http.Request), store func() CacheStore, p CachePolicy) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		directives := cacheControl(req.Header)
+		if _, ok := directives["no-store"]; ok || req.Method != "GET" || isAuthenticated(req) {
+			h(resp, req)
+			return
+		}
+		key := cacheKey(req, p)
+		_, noCache := directives["no-cache"]
+		if !noCache && directives["max-age"] != "0" {
+			if cached, ok := store().Get(key); ok && !cached.expired(time.Now()) {
+				writeCached(resp, req, cached, "HIT")
+				return
+			}
+		}
+		buffered := &bufferedResponse{header: make(http.Header)}
+		h(buffered, req)
+		addVary(buffered.header, p.Headers)
+		now := time.Now()
+		cached := &CachedResponse{Status: buffered.status, Header: buffered.header, Body: buffered.body.Bytes(),
+			Created: now, Expires: now.Add(p.TTL)}
+		if cached.Status == 0 {
+			cached.Status = http.StatusOK
+		}
+		_, noStore := cacheControl(buffered.header)["no-store"]
+		_, private := cacheControl(buffered.header)["private"]
+		_, setCookie := buffered.header["Set-Cookie"]
+		if cached.Status == http.StatusOK && !noStore && !private && !setCookie {
+			cached.ETag = buffered.header.Get("ETag")
+			if len(cached.ETag) == 0 {
+				sum := sha1.Sum(cached.Body)
+				cached.ETag = `"` + hex.EncodeToString(sum[:]) + `"`
+			}
+			store().Set(key, cached)
+		}
+		writeCached(resp, req, cached, "MISS")
+	}
+}
+
+// isAuthenticated returns true if req is verified by an authentication Interceptor, or has credentials
+func isAuthenticated(req *http.Request) bool {
+	return Auth(req) != nil || len(req.Header.Get("Authorization")) > 0
+}
+
+// writeCached writes r to resp, with "ETag", "Cache-Control" and "Age" headers if it's cacheable
+func writeCached(resp http.ResponseWriter, req *http.Request, r *CachedResponse, status string) {
+	for k, v := range r.Header {
+		resp.Header()[k] = v
+	}
+	if len(r.ETag) > 0 {
+		now := time.Now()
+		resp.Header().Set("ETag", r.ETag)
+		resp.Header().Set("X-Cache", status)
+		if len(r.Header.Get("Cache-Control")) == 0 {
+			resp.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int((r.Expires.Sub(now)+time.Second-1)/time.Second)))
+		}
+		if status == "HIT" {
+			resp.Header().Set("Age", strconv.Itoa(int(now.Sub(r.Created)/time.Second)))
+		}
+		if etagMatches(req, r.ETag) {
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	resp.WriteHeader(r.Status)
+	resp.Write(r.Body)
+}
@@ -0,0 +1,220 @@
+package turbo
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2)
+	fresh := func(body string) *CachedResponse {
+		return &CachedResponse{Body: []byte(body), Expires: time.Now().Add(time.Minute)}
+	}
+	c.Set("/a", fresh("a"))
+	c.Set("/b", fresh("b"))
+	_, ok := c.Get("/a")
+	assert.True(t, ok)
+	c.Set("/c", fresh("c"))
+	_, ok = c.Get("/b")
+	assert.False(t, ok, "the least recently used entry should be evicted")
+	assert.Equal(t, 2, c.Len())
+
+	c.Set("/expired", &CachedResponse{Expires: time.Now().Add(-time.Second)})
+	_, ok = c.Get("/expired")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+
+	c = NewLRUCache(10)
+	c.Set("/a", fresh("a"))
+	c.Set("/a/1", fresh("a1"))
+	c.Set("/b", fresh("b"))
+	assert.Equal(t, 2, c.Invalidate("/a"))
+	assert.Equal(t, 1, c.Invalidate(""))
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestWithCache(t *testing.T) {
+	calls := 0
+	h := func(resp http.ResponseWriter, req *http.Request) {
+		calls++
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Write([]byte(`{"message":"hello ` + req.Header.Get("Accept-Language") + `","calls":` + strconv.Itoa(calls) + `}`))
+	}
+	store := NewLRUCache(10)
+	cached := withCache(h, func() CacheStore { return store }, CachePolicy{TTL: time.Minute, Headers: []string{"accept-language"}})
+	get := func(url string, header map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		cached(w, req)
+		return w
+	}
+
+	w := get("/hello?b=2&a=1", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "max-age=60", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "Accept-Language", w.Header().Get("Vary"))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	w = get("/hello?a=1&b=2", nil)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"message":"hello ","calls":1}`, w.Body.String())
+	assert.Equal(t, []string{"Accept-Language"}, w.Header()["Vary"])
+	assert.Equal(t, 1, calls)
+
+	w = get("/hello?a=1&b=2", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = get("/hello?a=1&b=2", map[string]string{"Accept-Language": "fr"})
+	assert.Equal(t, `{"message":"hello fr","calls":2}`, w.Body.String(), "headers in policy are part of the key")
+
+	w = get("/hello?a=1&b=2", map[string]string{"Cache-Control": "no-cache"})
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, 3, calls)
+	w = get("/hello?a=1&b=2", map[string]string{"Cache-Control": "no-store"})
+	assert.Empty(t, w.Header().Get("X-Cache"))
+	assert.Equal(t, 4, calls)
+	w = get("/hello?a=1&b=2", nil)
+	assert.Equal(t, `{"message":"hello ","calls":3}`, w.Body.String(), "no-cache refreshes the cache")
+}
+
+func TestWithCacheSkipsUncacheableResponses(t *testing.T) {
+	calls := 0
+	store := NewLRUCache(10)
+	cached := withCache(func(resp http.ResponseWriter, req *http.Request) {
+		calls++
+		if req.URL.Path == "/private" {
+			resp.Header().Set("Cache-Control", "private")
+			resp.Write([]byte("private"))
+			return
+		}
+		if req.URL.Path == "/cookie" {
+			http.SetCookie(resp, &http.Cookie{Name: "session", Value: "secret"})
+			resp.Write([]byte("cookie"))
+			return
+		}
+		http.Error(resp, "failed", http.StatusInternalServerError)
+	}, func() CacheStore { return store }, CachePolicy{TTL: time.Minute})
+	for _, path := range []string{"/private", "/private", "/cookie", "/cookie", "/failed", "/failed"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		cached(w, req)
+		assert.Empty(t, w.Header().Get("ETag"))
+	}
+	assert.Equal(t, 6, calls)
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestCacheConfig(t *testing.T) {
+	file, remove := writeTestConfig(t, `config:
+  http_port: 8081
+  cache_size: 50
+urlmapping:
+  - GET /hello SayHello
+cache:
+  - GET /hello 30s Accept-Language,X-Tenant
+routes:
+  - method: GET
+    path: /user
+    rpc: GetUser
+    cache:
+      ttl: 1m
+      headers: [Authorization]
+`)
+	defer remove()
+	c := NewConfig("grpc", file)
+	assert.Equal(t, int64(50), c.CacheSize())
+	p, ok := c.routeCache("GET", "/hello")
+	assert.True(t, ok)
+	assert.Equal(t, CachePolicy{TTL: time.Second * 30, Headers: []string{"Accept-Language", "X-Tenant"}}, p)
+	p, ok = c.routeCache("GET", "/user")
+	assert.True(t, ok)
+	assert.Equal(t, CachePolicy{TTL: time.Minute, Headers: []string{"Authorization"}}, p)
+
+	invalid, remove := writeTestConfig(t, `urlmapping:
+  - GET /hello SayHello
+cache:
+  - GET /hello forever
+`)
+	defer remove()
+	defer func() {
+		assert.Equal(t, invalid+`:4: invalid [cache] "GET /hello forever", TTL should be a duration like "30s"`, recover())
+	}()
+	NewConfig("grpc", invalid)
+}
+
+func TestAdminInvalidateCache(t *testing.T) {
+	s := &GrpcServer{Server: &Server{Config: NewConfigFromMap("grpc", map[string]string{httpPort: "8081"})}}
+	s.cache().Set("/hello?a=1", &CachedResponse{Expires: time.Now().Add(time.Minute)})
+	s.cache().Set("/user", &CachedResponse{Expires: time.Now().Add(time.Minute)})
+	req, _ := http.NewRequest("POST", "/cache/invalidate?path=/hello", nil)
+	w := httptest.NewRecorder()
+	adminRouter(s).ServeHTTP(w, req)
+	assert.Equal(t, `{"invalidated":1,"path":"/hello"}`, w.Body.String())
+
+	store := NewLRUCache(1)
+	s.SetCacheStore(store)
+	store.Set("/user", &CachedResponse{Expires: time.Now().Add(time.Minute)})
+	assert.Equal(t, 1, s.InvalidateCache(""))
+}
+
+func TestRouteCacheOnlyForGet(t *testing.T) {
+	_, err := parseRoute(map[string]interface{}{"method": "POST", "path": "/hello", "rpc": "SayHello",
+		"cache": map[string]interface{}{"ttl": "30s"}})
+	assert.Equal(t, errors.New("[cache] only works with GET, got: POST"), err)
+}
+
+func TestCacheAfterAuthorization(t *testing.T) {
+	calls := 0
+	s := setupRoutedServer(t, `config:
+  http_port: 8081
+  auth_jwt_hmac_secret: secret
+urlmapping:
+  - GET /profile SayHello
+  - GET /public SayHello
+hijacker:
+  - GET /profile counter
+  - GET /public counter
+global_interceptor: JWTAuth
+interceptor:
+  - GET /public !JWTAuth
+cache:
+  - GET /profile 30s
+  - GET /public 30s
+`, func(s *Server) {
+		s.RegisterHijacker("counter", func(resp http.ResponseWriter, req *http.Request) {
+			calls++
+			if req.URL.Path == "/profile" {
+				resp.Header().Set("Set-Cookie", "session="+strconv.Itoa(calls))
+			}
+			resp.Write([]byte("calls: " + strconv.Itoa(calls)))
+		})
+	})
+	token := signJWT(t, "HS256", "", []byte("secret"), map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Minute).Unix()})
+
+	w := routedRequest(s, "GET", "/profile", map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, "calls: 1", w.Body.String())
+	w = routedRequest(s, "GET", "/profile", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "", w.Header().Get("Set-Cookie"))
+	w = routedRequest(s, "GET", "/profile", map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, "calls: 2", w.Body.String(), "authenticated responses are not cached")
+	assert.Equal(t, "", w.Header().Get("X-Cache"))
+
+	w = routedRequest(s, "GET", "/public", nil)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	w = routedRequest(s, "GET", "/public", nil)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, "calls: 3", w.Body.String())
+}
@@ -0,0 +1,139 @@
+package turbo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+type coalesceKey struct{}
+
+// flightGroup collapses calls with the same key which are in flight at the same time into one
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg   sync.WaitGroup
+	resp interface{}
+	err  error
+}
+
+// do calls fn, or waits for the call with the same key in flight, shared is true if the result is
+// from a call made by another caller. If fn panics, the panic goes on in the caller, waiters get an error.
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (resp interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.resp, c.err, true
+	}
+	c := new(flightCall)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		var e interface{}
+		if !returned {
+			e = recover()
+			c.resp, c.err = nil, fmt.Errorf("turbo: the coalesced call failed, error: %v", e)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+		if e != nil {
+			panic(e)
+		}
+	}()
+	c.resp, c.err = fn()
+	returned = true
+	return c.resp, c.err, false
+}
+
+// withCoalescing enables Coalesce() for requests of a route, identical calls of the route are collapsed in g
+func withCoalescing(h func(http.ResponseWriter, *http.Request), g *flightGroup) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		h(resp, req.WithContext(context.WithValue(req.Context(), coalesceKey{}, g)))
+	}
+}
+
+// Coalesce calls the backend with call, if "coalesce" is enabled for the route of req, concurrent calls
+// of methodName with an identical request message are collapsed into one, and the response is fanned out.
+// The shared call is made with the context of the first request, if it's cancelled, other requests call again.
+// Authenticated requests, and requests with an "Authorization" header, are not coalesced, since keys don't contain the identity.
+// Responses which are proto messages are cloned for each request, other responses are shared,
+// grpc headers and trailers are only received by the first request.
+func Coalesce(req *http.Request, methodName string, request interface{}, call func() (interface{}, error)) (interface{}, error) {
+	g, ok := req.Context().Value(coalesceKey{}).(*flightGroup)
+	if !ok || isAuthenticated(req) {
+		return call()
+	}
+	key, err := coalesceRequestKey(methodName, request)
+	if err != nil {
+		log.Warnf("turbo: failed to coalesce %s, error: %s", methodName, err)
+		return call()
+	}
+	resp, err, shared := g.do(key, call)
+	if !shared {
+		return resp, err
+	}
+	if canceled(err) && req.Context().Err() == nil {
+		return call()
+	}
+	if m, ok := resp.(proto.Message); ok && !reflect.ValueOf(m).IsNil() {
+		resp = proto.Clone(m)
+	}
+	return resp, err
+}
+
+// canceled returns true if err is caused by a cancelled or timed out context
+func canceled(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return true
+	}
+	if st, ok := status.FromError(err); ok {
+		return st.Code() == codes.Canceled || st.Code() == codes.DeadlineExceeded
+	}
+	return false
+}
+
+// coalesceRequestKey returns methodName and the json of request, request is
+// a proto message for grpc, and a list of parameters in reflect.Value for thrift.
+func coalesceRequestKey(methodName string, request interface{}) (string, error) {
+	if m, ok := request.(proto.Message); ok {
+		s, err := (&jsonpb.Marshaler{}).MarshalToString(m)
+		if err != nil {
+			return "", err
+		}
+		return methodName + " " + s, nil
+	}
+	if params, ok := request.([]reflect.Value); ok {
+		values := make([]interface{}, 0, len(params))
+		for _, p := range params {
+			values = append(values, p.Interface())
+		}
+		request = values
+	}
+	b, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	return methodName + " " + string(b), nil
+}
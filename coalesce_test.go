@@ -0,0 +1,159 @@
+package turbo
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testMessage struct {
+	Message string `protobuf:"bytes,1,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *testMessage) Reset()         { *m = testMessage{} }
+func (m *testMessage) String() string { return m.Message }
+func (m *testMessage) ProtoMessage()  {}
+
+func TestCoalesce(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	call := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &testMessage{Message: "hello"}, nil
+	}
+	responses := make(chan interface{}, 11)
+	h := withCoalescing(func(resp http.ResponseWriter, req *http.Request) {
+		r, err := Coalesce(req, "SayHello", &testMessage{Message: req.URL.Query().Get("name")}, call)
+		assert.Nil(t, err)
+		responses <- r
+	}, new(flightGroup))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "/hello?name=turbo", nil)
+			h(httptest.NewRecorder(), req)
+		}()
+	}
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	seen := make(map[interface{}]bool)
+	for i := 0; i < 10; i++ {
+		r := <-responses
+		assert.Equal(t, "hello", r.(*testMessage).Message)
+		assert.False(t, seen[r], "proto responses should be cloned")
+		seen[r] = true
+	}
+
+	req, _ := http.NewRequest("GET", "/hello?name=other", nil)
+	h(httptest.NewRecorder(), req)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "different requests should not be collapsed")
+
+	req, _ = http.NewRequest("GET", "/hello", nil)
+	Coalesce(req, "SayHello", &testMessage{}, call)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "coalescing should be disabled without withCoalescing")
+}
+
+func TestCoalesceLeaderCancelled(t *testing.T) {
+	g := new(flightGroup)
+	started := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	leader, _ := http.NewRequest("GET", "/hello", nil)
+	leader = leader.WithContext(context.WithValue(ctx, coalesceKey{}, g))
+	done := make(chan error)
+	go func() {
+		_, err := Coalesce(leader, "SayHello", []string{"turbo"}, func() (interface{}, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		done <- err
+	}()
+	<-started
+
+	follower, _ := http.NewRequest("GET", "/hello", nil)
+	follower = follower.WithContext(context.WithValue(context.Background(), coalesceKey{}, g))
+	result := make(chan interface{})
+	go func() {
+		r, err := Coalesce(follower, "SayHello", []string{"turbo"}, func() (interface{}, error) { return "retried", nil })
+		assert.Nil(t, err)
+		result <- r
+	}()
+	time.Sleep(time.Millisecond * 20)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, "retried", <-result)
+}
+
+func TestCoalescePanicAndAuthorization(t *testing.T) {
+	g := new(flightGroup)
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		g.do("key", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+	done := make(chan error)
+	go func() {
+		_, err, _ := g.do("key", func() (interface{}, error) { return nil, nil })
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 20)
+	close(release)
+	assert.EqualError(t, <-done, "turbo: the coalesced call failed, error: boom")
+
+	var calls int32
+	h := withCoalescing(func(resp http.ResponseWriter, req *http.Request) {
+		Coalesce(req, "SayHello", &testMessage{Message: "turbo"}, func() (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(time.Millisecond * 20)
+			return nil, nil
+		})
+	}, g)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "/hello", nil)
+			req.Header.Set("Authorization", "Bearer token")
+			h(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "requests with credentials should not be collapsed")
+}
+
+func TestCoalesceConfig(t *testing.T) {
+	file, remove := writeTestConfig(t, `config:
+  http_port: 8081
+urlmapping:
+  - GET /hello SayHello
+coalesce:
+  - GET /hello
+routes:
+  - method: GET
+    path: /user
+    rpc: GetUser
+    coalesce: true
+`)
+	defer remove()
+	c := NewConfig("grpc", file)
+	assert.True(t, c.coalesced["GET /hello"])
+	assert.True(t, c.coalesced["GET /user"])
+	assert.False(t, c.coalesced["POST /hello"])
+}
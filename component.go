@@ -1,36 +1,118 @@
 package turbo
 
 import (
+	"fmt"
 	"github.com/gorilla/mux"
+	"math"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 )
 
 // Components holds all component mappings
 type Components struct {
-	commonInterceptors   Interceptors
+	commonInterceptors   []namedInterceptor
+	interceptorRules     []interceptorRule
+	priorities           map[string]int
 	routers              map[int]*mux.Router
 	convertorMap         map[string]Convertor
 	errorHandler         ErrorHandlerFunc
 	registeredComponents map[string]interface{}
+	// builtinComponents are built-in components set up in config, e.g. JWTAuth
+	builtinComponents map[string]interface{}
+	authzRules        []authzRule
 }
 
 // Reset resets all component mappings
 func (c *Components) Reset() {
-	c.commonInterceptors = Interceptors{}
+	c.commonInterceptors = nil
+	c.interceptorRules = nil
+	c.priorities = nil
 	c.routers = make(map[int]*mux.Router)
 	c.convertorMap = make(map[string]Convertor)
 	c.errorHandler = nil
+	c.authzRules = nil
 }
 
 const (
-	rInterceptor = iota
-	rPreprocessor
+	rPreprocessor = iota
 	rPostprocessor
+	rTransformer
 	rHijacker
 )
 
+const (
+	kindInterceptor   = "interceptor"
+	kindPreprocessor  = "preprocessor"
+	kindPostprocessor = "postprocessor"
+	kindTransformer   = "transformer"
+	kindHijacker      = "hijacker"
+	kindConvertor     = "convertor"
+	kindErrorHandler  = "errorhandler"
+	kindPolicy        = "policy"
+	kindMiddleware    = "middleware"
+	// kindThriftInterceptor is the kind of ThriftInterceptor, used in "thrift_interceptor"
+	kindThriftInterceptor = "thrift_interceptor"
+)
+
+// componentKind returns the kind of component, "" if it's not a component
+func componentKind(component interface{}) string {
+	switch component.(type) {
+	case Preprocessor:
+		return kindPreprocessor
+	case Postprocessor:
+		return kindPostprocessor
+	case ResponseTransformer:
+		return kindTransformer
+	case Hijacker:
+		return kindHijacker
+	case Convertor:
+		return kindConvertor
+	case ErrorHandlerFunc:
+		return kindErrorHandler
+	case AuthzPolicy:
+		return kindPolicy
+	case Middleware:
+		return kindMiddleware
+	case Interceptor:
+		return kindInterceptor
+	case ThriftInterceptor:
+		return kindThriftInterceptor
+	}
+	return ""
+}
+
+// asComponent converts funcs with the signature of a component to that component,
+// e.g. a func(http.ResponseWriter, *http.Request) error is converted to a Preprocessor,
+// an error is returned if component is not a component.
+func asComponent(component interface{}) (interface{}, error) {
+	switch c := component.(type) {
+	case func(http.ResponseWriter, *http.Request) error:
+		return Preprocessor(c), nil
+	case func(http.ResponseWriter, *http.Request, interface{}, error):
+		return Postprocessor(c), nil
+	case func(http.ResponseWriter, *http.Request, interface{}) (interface{}, error):
+		return ResponseTransformer(c), nil
+	case func(http.ResponseWriter, *http.Request):
+		return Hijacker(c), nil
+	case http.HandlerFunc:
+		return Hijacker(c), nil
+	case func(r *http.Request) reflect.Value:
+		return Convertor(c), nil
+	case func(http.ResponseWriter, *http.Request, error):
+		return ErrorHandlerFunc(c), nil
+	case func(*http.Request, *AuthInfo) error:
+		return AuthzPolicyFunc(c), nil
+	case func(http.Handler) http.Handler:
+		return Middleware(c), nil
+	}
+	if componentKind(component) == "" {
+		return nil, fmt.Errorf("%T is not an Interceptor, Preprocessor, Postprocessor, ResponseTransformer, Hijacker, Convertor, ErrorHandlerFunc, AuthzPolicy, Middleware or ThriftInterceptor", component)
+	}
+	return component, nil
+}
+
 // Interceptor -----------------
 
 // Interceptor intercepts requests, can run a func before and after a request
@@ -54,29 +136,114 @@ func (i *BaseInterceptor) After(resp http.ResponseWriter, req *http.Request) err
 
 type Interceptors []Interceptor
 
-func (c *Components) setCommonInterceptor(interceptors Interceptors) {
+// ServeHTTP is an empty func, only for implementing http.Handler
+func (i Interceptors) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// namedInterceptor is an Interceptor with the name it's registered with, name is "" if it's set in code
+type namedInterceptor struct {
+	name string
+	Interceptor
+}
+
+func unnamed(list []Interceptor) []namedInterceptor {
+	result := make([]namedInterceptor, 0, len(list))
+	for _, i := range list {
+		result = append(result, namedInterceptor{Interceptor: i})
+	}
+	return result
+}
+
+// interceptorRule attaches interceptors to requests matching route, and excludes interceptors by name
+type interceptorRule struct {
+	route *mux.Route
+	// prefix is set if it's a group rule, which matches urls starting with prefix, e.g. "/api/"
+	prefix       string
+	interceptors []namedInterceptor
+	excludes     []string
+}
+
+// rank orders rules, group rules with shorter prefixes come first, then route rules
+func (r interceptorRule) rank() int {
+	if len(r.prefix) > 0 {
+		return len(r.prefix)
+	}
+	return math.MaxInt32
+}
+
+func (c *Components) setCommonInterceptor(interceptors []namedInterceptor) {
 	c.commonInterceptors = interceptors
 }
 
 func (c *Components) commonInterceptor() Interceptors {
-	if c.commonInterceptors != nil {
-		return c.commonInterceptors
+	result := make(Interceptors, 0, len(c.commonInterceptors))
+	for _, i := range c.commonInterceptors {
+		result = append(result, i.Interceptor)
 	}
-	return Interceptors{}
+	return result
 }
 
-// ServeHTTP is an empty func, only for implementing http.Handler
-func (i Interceptors) ServeHTTP(http.ResponseWriter, *http.Request) {}
+func (c *Components) intercept(methods []string, urlPattern string, list []namedInterceptor, excludes []string) {
+	route := mux.NewRouter().NewRoute()
+	prefix := ""
+	if strings.HasSuffix(urlPattern, "/") {
+		route.PathPrefix(urlPattern)
+		prefix = urlPattern
+	} else {
+		route.Path(urlPattern)
+	}
+	if len(methods) > 0 {
+		route.Methods(methods...)
+	}
+	c.interceptorRules = append(c.interceptorRules,
+		interceptorRule{route: route, prefix: prefix, interceptors: list, excludes: excludes})
+}
 
-func (c *Components) intercept(methods []string, urlPattern string, list ...Interceptor) {
-	c.routers[rInterceptor] = setComponent(c.routers[rInterceptor], methods, urlPattern, Interceptors(list))
+func (c *Components) setInterceptorPriority(name string, priority int) {
+	if c.priorities == nil {
+		c.priorities = make(map[string]int)
+	}
+	c.priorities[name] = priority
 }
 
+// interceptors composes interceptors for req in order: common interceptors, interceptors of matching groups,
+// from the shortest prefix to the longest, then interceptors of matching routes.
+// Excluded interceptors are removed, an interceptor with a name runs only once, and interceptors with
+// higher priorities are moved to the front.
 func (c *Components) interceptors(req *http.Request) Interceptors {
-	if cp := component(c.routers[rInterceptor], req); cp != nil {
-		return cp.(Interceptors)
+	matched := make([]interceptorRule, 0)
+	for _, r := range c.interceptorRules {
+		if r.route.Match(req, &mux.RouteMatch{}) {
+			matched = append(matched, r)
+		}
 	}
-	return nil
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].rank() < matched[j].rank() })
+	chain := append([]namedInterceptor{}, c.commonInterceptors...)
+	excluded := make(map[string]bool)
+	for _, r := range matched {
+		chain = append(chain, r.interceptors...)
+		for _, name := range r.excludes {
+			excluded[name] = true
+		}
+	}
+	composed := make([]namedInterceptor, 0, len(chain))
+	seen := make(map[string]bool)
+	for _, i := range chain {
+		if len(i.name) > 0 {
+			if excluded[i.name] || seen[i.name] {
+				continue
+			}
+			seen[i.name] = true
+		}
+		composed = append(composed, i)
+	}
+	sort.SliceStable(composed, func(i, j int) bool {
+		return c.priorities[composed[i].name] > c.priorities[composed[j].name]
+	})
+	result := make(Interceptors, 0, len(composed))
+	for _, i := range composed {
+		result = append(result, i.Interceptor)
+	}
+	return result
 }
 
 // PreProcessor-------------
@@ -85,13 +252,19 @@ type Preprocessor func(http.ResponseWriter, *http.Request) error
 // ServeHTTP is an empty func, only for implementing http.Handler
 func (p Preprocessor) ServeHTTP(http.ResponseWriter, *http.Request) {}
 
-func (c *Components) setPreprocessor(methods []string, urlPattern string, pre Preprocessor) {
-	c.routers[rPreprocessor] = setComponent(c.routers[rPreprocessor], methods, urlPattern, pre)
+// Preprocessors is an ordered chain of Preprocessors, they run one by one until one of them returns an error
+type Preprocessors []Preprocessor
+
+// ServeHTTP is an empty func, only for implementing http.Handler
+func (p Preprocessors) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+func (c *Components) setPreprocessors(methods []string, urlPattern string, list Preprocessors) {
+	c.routers[rPreprocessor] = setComponent(c.routers[rPreprocessor], methods, urlPattern, list)
 }
 
-func (c *Components) preprocessor(req *http.Request) Preprocessor {
+func (c *Components) preprocessors(req *http.Request) Preprocessors {
 	if cp := component(c.routers[rPreprocessor], req); cp != nil {
-		return cp.(Preprocessor)
+		return cp.(Preprocessors)
 	}
 	return nil
 }
@@ -113,6 +286,28 @@ func (c *Components) postprocessor(req *http.Request) Postprocessor {
 	return nil
 }
 
+// ResponseTransformer--------
+// ResponseTransformer transforms the response object, and passes the result to the next postprocessor,
+// the last result is written as json if the chain doesn't end with a Postprocessor.
+type ResponseTransformer func(http.ResponseWriter, *http.Request, interface{}) (interface{}, error)
+
+// ResponseTransformers is an ordered chain of ResponseTransformers
+type ResponseTransformers []ResponseTransformer
+
+// ServeHTTP is an empty func, only for implementing http.Handler
+func (t ResponseTransformers) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+func (c *Components) setResponseTransformers(methods []string, urlPattern string, list ResponseTransformers) {
+	c.routers[rTransformer] = setComponent(c.routers[rTransformer], methods, urlPattern, list)
+}
+
+func (c *Components) responseTransformers(req *http.Request) ResponseTransformers {
+	if cp := component(c.routers[rTransformer], req); cp != nil {
+		return cp.(ResponseTransformers)
+	}
+	return nil
+}
+
 // Hijacker-----------------
 type Hijacker func(http.ResponseWriter, *http.Request)
 
@@ -174,7 +369,23 @@ func (c *Components) convertor(theType string) Convertor {
 // ErrorHandler----------
 type ErrorHandlerFunc func(http.ResponseWriter, *http.Request, error)
 
+// HTTPError is an error with a HTTP status, the default error handler responds with Status and Header
+type HTTPError struct {
+	Status  int
+	Message string
+	Header  http.Header
+}
+
+func (e *HTTPError) Error() string { return e.Message }
+
 func defaultErrorHandler(resp http.ResponseWriter, req *http.Request, err error) {
+	if e, ok := err.(*HTTPError); ok {
+		for k, v := range e.Header {
+			resp.Header()[k] = v
+		}
+		http.Error(resp, e.Message, e.Status)
+		return
+	}
 	http.Error(resp, err.Error(), http.StatusInternalServerError)
 }
 
@@ -190,34 +401,62 @@ func (c *Components) WithErrorHandler(e ErrorHandlerFunc) {
 	c.errorHandler = e
 }
 
-// SetCommonInterceptor assigns Interceptors to all URLs, if the URL has no other Interceptors assigned
+// SetCommonInterceptor assigns Interceptors to all URLs, they run before group and route Interceptors
 func (c *Components) SetCommonInterceptor(interceptors ...Interceptor) {
-	c.setCommonInterceptor(interceptors)
+	c.setCommonInterceptor(unnamed(interceptors))
 }
 
-// CommonInterceptors returns a list of Interceptors which are default
+// CommonInterceptors returns a list of Interceptors which are assigned to all URLs
 func (c *Components) CommonInterceptors() []Interceptor {
 	return c.commonInterceptor()
 }
 
-// Intercept registers a list of Interceptors to an URL pattern at given HTTP methods
+// Intercept registers a list of Interceptors to an URL pattern at given HTTP methods,
+// an URL pattern ending with "/" is a group, which matches all URLs with this prefix.
 func (c *Components) Intercept(methods []string, urlPattern string, list ...Interceptor) {
-	c.intercept(methods, urlPattern, list...)
+	c.intercept(methods, urlPattern, unnamed(list), nil)
+}
+
+// SetInterceptorPriority sets the priority of the Interceptor registered with name, defaults to 0,
+// Interceptors with higher priorities run Before() earlier, and After() later.
+func (c *Components) SetInterceptorPriority(name string, priority int) {
+	c.setInterceptorPriority(name, priority)
 }
 
-// Interceptors returns a list of Interceptors for this request
+// Interceptors returns the composed list of Interceptors for this request
 func (c *Components) Interceptors(req *http.Request) Interceptors {
 	return c.interceptors(req)
 }
 
 // SetPreprocessor registers a preprocessor to an URL pattern
 func (c *Components) SetPreprocessor(methods []string, urlPattern string, pre Preprocessor) {
-	c.setPreprocessor(methods, urlPattern, pre)
+	c.setPreprocessors(methods, urlPattern, Preprocessors{pre})
+}
+
+// SetPreprocessors registers a chain of preprocessors to an URL pattern, they run in order
+func (c *Components) SetPreprocessors(methods []string, urlPattern string, list ...Preprocessor) {
+	c.setPreprocessors(methods, urlPattern, Preprocessors(list))
 }
 
-// Preprocessor returns a preprocessor for this request
+// Preprocessor returns a preprocessor for this request, which runs the chain of preprocessors in order
 func (c *Components) Preprocessor(req *http.Request) Preprocessor {
-	return c.preprocessor(req)
+	list := c.preprocessors(req)
+	if len(list) == 0 {
+		return nil
+	}
+	return func(resp http.ResponseWriter, req *http.Request) error {
+		for _, pre := range list {
+			if err := pre(resp, req); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Preprocessors returns the chain of preprocessors for this request
+func (c *Components) Preprocessors(req *http.Request) Preprocessors {
+	return c.preprocessors(req)
 }
 
 // SetPostprocessor registers a Postprocessor to an URL pattern
@@ -230,6 +469,17 @@ func (c *Components) Postprocessor(req *http.Request) Postprocessor {
 	return c.postprocessor(req)
 }
 
+// SetResponseTransformers registers a chain of ResponseTransformers to an URL pattern, they run in order
+// before the Postprocessor, or the default json writer.
+func (c *Components) SetResponseTransformers(methods []string, urlPattern string, list ...ResponseTransformer) {
+	c.setResponseTransformers(methods, urlPattern, ResponseTransformers(list))
+}
+
+// ResponseTransformers returns the chain of ResponseTransformers for this request
+func (c *Components) ResponseTransformers(req *http.Request) ResponseTransformers {
+	return c.responseTransformers(req)
+}
+
 // SetHijacker registers a Hijacker to an URL pattern
 func (c *Components) SetHijacker(methods []string, urlPattern string, h Hijacker) {
 	c.setHijacker(methods, urlPattern, h)
@@ -250,3 +500,8 @@ func (c *Components) SetConvertor(field string, convertorFunc Convertor) {
 func (c *Components) Convertor(theType string) Convertor {
 	return c.convertor(theType)
 }
+
+// AddAuthzRule adds an authorization rule, policy is used instead of the registered one named rule.Policy
+func (c *Components) AddAuthzRule(rule AuthzRule, policy AuthzPolicy) {
+	c.addAuthzRule(rule, policy)
+}
@@ -0,0 +1,155 @@
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type namedTestInterceptor struct {
+	BaseInterceptor
+	name string
+}
+
+func interceptorNames(list Interceptors) []string {
+	names := make([]string, 0)
+	for _, i := range list {
+		names = append(names, i.(*namedTestInterceptor).name)
+	}
+	return names
+}
+
+func TestInterceptorComposition(t *testing.T) {
+	s, remove := setupServerWithConfig(t, `config:
+  http_port: 8081
+global_interceptor:
+  - Log
+  - Auth
+interceptor:
+  - GET /api/v1/ V1
+  - GET /api/ Api
+  - GET /api/v1/public !Auth,Public
+  - GET /api/v1/users/{id} Log,User
+interceptor_priority:
+  - Metrics 10
+routes:
+  - method: GET
+    path: /api/v1/metrics
+    rpc: SayHello
+    interceptors: [Metrics]
+`)
+	defer remove()
+	for _, name := range []string{"Log", "Auth", "V1", "Api", "Public", "User", "Metrics"} {
+		s.RegisterInterceptor(name, &namedTestInterceptor{name: name})
+	}
+	c := s.loadComponents(s.Config)
+
+	get := func(path string) []string {
+		req, _ := http.NewRequest("GET", path, nil)
+		return interceptorNames(c.Interceptors(req))
+	}
+	assert.Equal(t, []string{"Log", "Auth"}, get("/hello"))
+	assert.Equal(t, []string{"Log", "Auth", "Api"}, get("/api/hello"))
+	assert.Equal(t, []string{"Log", "Auth", "Api", "V1"}, get("/api/v1/hello"))
+	assert.Equal(t, []string{"Log", "Api", "V1", "Public"}, get("/api/v1/public"))
+	assert.Equal(t, []string{"Log", "Auth", "Api", "V1", "User"}, get("/api/v1/users/1"))
+	assert.Equal(t, []string{"Metrics", "Log", "Auth", "Api", "V1"}, get("/api/v1/metrics"))
+
+	req, _ := http.NewRequest("POST", "/api/v1/public", nil)
+	assert.Equal(t, []string{"Log", "Auth"}, interceptorNames(c.Interceptors(req)))
+}
+
+func TestCommonInterceptorInCode(t *testing.T) {
+	c := &Components{}
+	common, route := &namedTestInterceptor{name: "common"}, &namedTestInterceptor{name: "route"}
+	c.SetCommonInterceptor(common)
+	c.Intercept([]string{"GET"}, "/hello", route)
+	req, _ := http.NewRequest("GET", "/hello", nil)
+	assert.Equal(t, []string{"common", "route"}, interceptorNames(c.Interceptors(req)))
+	c.Reset()
+	assert.Equal(t, []string{}, interceptorNames(c.Interceptors(req)))
+}
+
+func TestProcessorChains(t *testing.T) {
+	s, remove := setupServerWithConfig(t, `config:
+  http_port: 8081
+preprocessor:
+  - GET /hello checkName,checkAge
+postprocessor:
+  - GET /hello addTimestamp,wrap
+  - GET /written addTimestamp,write
+  - GET /failed fail,write
+`)
+	defer remove()
+	calls := make([]string, 0)
+	s.RegisterPreprocessor("checkName", func(resp http.ResponseWriter, req *http.Request) error {
+		calls = append(calls, "checkName")
+		if req.Form.Get("name") == "" {
+			return errors.New("name is required")
+		}
+		return nil
+	})
+	s.RegisterPreprocessor("checkAge", func(http.ResponseWriter, *http.Request) error {
+		calls = append(calls, "checkAge")
+		return nil
+	})
+	s.RegisterResponseTransformer("addTimestamp", func(resp http.ResponseWriter, req *http.Request, r interface{}) (interface{}, error) {
+		m := r.(map[string]interface{})
+		m["timestamp"] = 1
+		return m, nil
+	})
+	s.RegisterResponseTransformer("wrap", func(resp http.ResponseWriter, req *http.Request, r interface{}) (interface{}, error) {
+		return map[string]interface{}{"data": r}, nil
+	})
+	s.RegisterResponseTransformer("fail", func(http.ResponseWriter, *http.Request, interface{}) (interface{}, error) {
+		return nil, errors.New("failed to transform")
+	})
+	s.RegisterPostprocessor("write", func(resp http.ResponseWriter, req *http.Request, r interface{}, err error) {
+		resp.Write([]byte(fmt.Sprintf("written: %v", r)))
+	})
+	s.Components = s.loadComponents(s.Config)
+
+	request := func(path string) *http.Request {
+		req, _ := http.NewRequest("GET", path, nil)
+		copyComponentsPtr(s, req)
+		parseRequestForm(req)
+		return req
+	}
+	assert.NotNil(t, doPreprocessor(s, httptest.NewRecorder(), request("/hello")))
+	assert.Equal(t, []string{"checkName"}, calls)
+	calls = calls[:0]
+	assert.Nil(t, doPreprocessor(s, httptest.NewRecorder(), request("/hello?name=turbo")))
+	assert.Equal(t, []string{"checkName", "checkAge"}, calls)
+
+	w := httptest.NewRecorder()
+	doPostprocessor(s, w, request("/hello"), map[string]interface{}{"message": "hi"}, nil)
+	assert.Equal(t, `{"data":{"message":"hi","timestamp":1}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	doPostprocessor(s, w, request("/written"), map[string]interface{}{}, nil)
+	assert.Equal(t, "written: map[timestamp:1]", w.Body.String())
+
+	w = httptest.NewRecorder()
+	doPostprocessor(s, w, request("/failed"), map[string]interface{}{}, nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "written")
+}
+
+func TestPostprocessorMustBeLast(t *testing.T) {
+	s, remove := setupServerWithConfig(t, `config:
+  http_port: 8081
+postprocessor:
+  - GET /hello write,addTimestamp
+`)
+	defer remove()
+	s.RegisterPostprocessor("write", func(http.ResponseWriter, *http.Request, interface{}, error) {})
+	s.RegisterResponseTransformer("addTimestamp", func(resp http.ResponseWriter, req *http.Request, r interface{}) (interface{}, error) {
+		return r, nil
+	})
+	_, err := s.loadComponentsNoPanic(s.Config)
+	assert.Equal(t, errors.New("turbo: failed to load components, error: "+
+		"postprocessor [write] writes the response, it must be the last one in [write,addTimestamp]"), err)
+}
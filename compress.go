@@ -0,0 +1,291 @@
+package turbo
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoding is a content encoding used to compress responses, and decompress requests, e.g. "gzip".
+// "gzip" and "deflate" are built in, others like "br" can be added with RegisterEncoding().
+type Encoding struct {
+	// Name is the token in "Accept-Encoding" and "Content-Encoding"
+	Name      string
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	encodingsMu sync.RWMutex
+	encodings   = map[string]Encoding{
+		"gzip": {
+			Name:      "gzip",
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+			NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		},
+		"deflate": {
+			Name:      "deflate",
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil },
+			NewReader: func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+		},
+	}
+)
+
+// RegisterEncoding adds or replaces a content encoding, e.g. brotli with github.com/andybalholm/brotli:
+//
+//	turbo.RegisterEncoding(turbo.Encoding{
+//		Name:      "br",
+//		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return brotli.NewWriter(w), nil },
+//		NewReader: func(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(brotli.NewReader(r)), nil },
+//	})
+//
+// then add it to "compression_encodings" in config to use it for responses.
+func RegisterEncoding(e Encoding) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	encodings[e.Name] = e
+}
+
+func encoding(name string) (Encoding, bool) {
+	encodingsMu.RLock()
+	defer encodingsMu.RUnlock()
+	e, ok := encodings[strings.ToLower(name)]
+	return e, ok
+}
+
+// CompressionPolicy is the compression setting of a route
+type CompressionPolicy struct {
+	// Encodings are used for responses in order of preference
+	Encodings []Encoding
+	// MinSize is the min size of a response body to compress
+	MinSize int
+}
+
+// negotiate returns the encoding to use for a request with accept, which is the "Accept-Encoding" header,
+// the one with the highest q value is chosen, ties are broken by the order of p.Encodings.
+func (p *CompressionPolicy) negotiate(accept string) (Encoding, bool) {
+	q := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(name) == 0 {
+			continue
+		}
+		weight := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					weight = f
+				}
+			}
+		}
+		q[name] = weight
+	}
+	best, bestQ := Encoding{}, 0.0
+	for _, e := range p.Encodings {
+		weight, ok := q[e.Name]
+		if !ok {
+			weight = q["*"]
+		}
+		if weight > bestQ {
+			best, bestQ = e, weight
+		}
+	}
+	return best, bestQ > 0
+}
+
+// withCompression decompresses request bodies with a "Content-Encoding", and compresses responses
+// with the encoding negotiated, if they are at least p.MinSize bytes.
+func withCompression(h func(http.ResponseWriter, *http.Request), p *CompressionPolicy) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if ce := strings.TrimSpace(req.Header.Get("Content-Encoding")); len(ce) > 0 && !strings.EqualFold(ce, "identity") {
+			e, ok := encoding(ce)
+			if !ok {
+				http.Error(resp, "turbo: unsupported Content-Encoding: "+ce, http.StatusUnsupportedMediaType)
+				return
+			}
+			r, err := e.NewReader(req.Body)
+			if err != nil {
+				http.Error(resp, fmt.Sprintf("turbo: invalid %s request body, error: %s", e.Name, err), http.StatusBadRequest)
+				return
+			}
+			defer r.Close()
+			// the size of the decompressed body is unknown, body limits apply to it when it's read
+			req.Body, req.ContentLength = r, -1
+			req.Header.Del("Content-Encoding")
+			req.Header.Del("Content-Length")
+		}
+		resp.Header().Add("Vary", "Accept-Encoding")
+		e, ok := p.negotiate(req.Header.Get("Accept-Encoding"))
+		if !ok || req.Method == "HEAD" {
+			h(resp, req)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: resp, encoding: e, minSize: p.MinSize}
+		defer cw.close()
+		h(cw, req)
+	}
+}
+
+// compressWriter buffers a response until it's known to be at least minSize bytes, then compresses it,
+// a smaller response is written as is.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding Encoding
+	minSize  int
+	status   int
+	buf      []byte
+	// w is set once the response is being compressed
+	w       io.WriteCloser
+	written bool
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if c.w != nil {
+		return c.w.Write(p)
+	}
+	if c.written {
+		return c.ResponseWriter.Write(p)
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.minSize {
+		if err := c.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start writes headers, and the buffered body, compressed if the response can be compressed
+func (c *compressWriter) start() (err error) {
+	h := c.Header()
+	compress := len(c.buf) >= c.minSize && len(h.Get("Content-Encoding")) == 0 &&
+		c.status != http.StatusNoContent && c.status != http.StatusNotModified && c.status >= http.StatusOK
+	if compress {
+		h.Set("Content-Encoding", c.encoding.Name)
+		h.Del("Content-Length")
+		// the compressed body is not byte-for-byte the same as the uncompressed one
+		if etag := h.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		if c.w, err = c.encoding.NewWriter(c.ResponseWriter); err != nil {
+			h.Del("Content-Encoding")
+			c.w = nil
+		}
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	c.written = true
+	buf := c.buf
+	c.buf = nil
+	if c.w != nil {
+		_, err = c.w.Write(buf)
+	} else {
+		_, err = c.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends the buffered response, it's compressed only if it's large enough
+func (c *compressWriter) Flush() {
+	if !c.written && c.status != 0 {
+		logErrorIf(c.start())
+	}
+	if f, ok := c.w.(interface{ Flush() error }); ok {
+		logErrorIf(f.Flush())
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *compressWriter) close() {
+	if !c.written && c.status != 0 {
+		logErrorIf(c.start())
+	}
+	if c.w != nil {
+		logErrorIf(c.w.Close())
+	}
+}
+
+// Compression returns "compression" in config file, responses of all routes are compressed if it's true,
+// routes can turn it on or off in "compress".
+func (c *Config) Compression() bool {
+	return strings.TrimSpace(c.configs[compression]) == "true"
+}
+
+// CompressionMinSize returns "compression_min_size" in config file, defaults to "1KB",
+// smaller responses are not compressed.
+func (c *Config) CompressionMinSize() int64 {
+	if len(strings.TrimSpace(c.configs[compressionMinSize])) == 0 {
+		return 1 << 10
+	}
+	return c.sizeValue(compressionMinSize)
+}
+
+// CompressionEncodings returns "compression_encodings" in config file in order of preference,
+// defaults to "gzip,deflate", an encoding other than them must be registered with RegisterEncoding().
+func (c *Config) CompressionEncodings() []string {
+	names, _ := parseNames(c.configs[compressionEncodings])
+	if len(names) == 0 {
+		return []string{"gzip", "deflate"}
+	}
+	return names
+}
+
+// compressionPolicy returns the compression policy in config
+func (c *Config) compressionPolicy() (*CompressionPolicy, error) {
+	p := &CompressionPolicy{MinSize: int(c.CompressionMinSize())}
+	unknown := make([]string, 0)
+	for _, name := range c.CompressionEncodings() {
+		if e, ok := encoding(name); ok {
+			p.Encodings = append(p.Encodings, e)
+		} else {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("turbo: unknown encodings in [%s]: %s, register them with RegisterEncoding()",
+			compressionEncodings, strings.Join(unknown, ", "))
+	}
+	return p, nil
+}
+
+// loadCompress loads lines like "GET /hello" or "GET /download off" in "compress",
+// which turn compression of the route on or off.
+func (c *Config) loadCompress() {
+	for i, line := range c.GetStringSlice("compress") {
+		values := strings.Fields(line)
+		if len(values) == 2 {
+			values = append(values, "on")
+		}
+		if len(values) != 3 || values[2] != "on" && values[2] != "off" {
+			panic(fmt.Sprintf("%s: invalid [compress] %q, should be \"METHODS PATH [on|off]\"", c.position("compress", i, line), line))
+		}
+		c.compressed[values[0]+" "+values[1]] = values[2] == "on"
+	}
+}
+
+// routeCompression returns whether responses of the route with methods and path are compressed
+func (c *Config) routeCompression(methods, path string) bool {
+	if on, ok := c.compressed[methods+" "+path]; ok {
+		return on
+	}
+	return c.Compression()
+}
@@ -0,0 +1,103 @@
+package turbo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	s := setupRoutedServer(t, `config:
+  http_port: 8081
+  compression: true
+  compression_min_size: 64
+urlmapping:
+  - GET /large SayHello
+  - GET /small SayHello
+  - POST /echo SayHello
+  - GET /plain SayHello
+hijacker:
+  - GET /large large
+  - GET /small hijacker
+  - POST /echo echo
+  - GET /plain large
+compress:
+  - GET /plain off
+`, func(s *Server) {
+		s.RegisterHijacker("large", func(resp http.ResponseWriter, req *http.Request) {
+			resp.Header().Set("ETag", `"v1"`)
+			resp.Write([]byte(strings.Repeat("turbo ", 10)))
+			resp.Write([]byte(strings.Repeat("turbo ", 10)))
+		})
+		s.RegisterHijacker("echo", func(resp http.ResponseWriter, req *http.Request) {
+			body, err := ioutil.ReadAll(req.Body)
+			assert.Nil(t, err)
+			resp.Write(body)
+		})
+	})
+	large := strings.Repeat("turbo ", 20)
+
+	w := routedRequest(s, "GET", "/large", map[string]string{"Accept-Encoding": "deflate;q=0.5, gzip"})
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(t, `W/"v1"`, w.Header().Get("ETag"))
+	r, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(r)
+	assert.Equal(t, large, string(body))
+
+	w = routedRequest(s, "GET", "/large", map[string]string{"Accept-Encoding": "gzip;q=0.1, *"})
+	assert.Equal(t, "deflate", w.Header().Get("Content-Encoding"))
+	zr, err := zlib.NewReader(w.Body)
+	assert.Nil(t, err)
+	body, _ = ioutil.ReadAll(zr)
+	assert.Equal(t, large, string(body))
+
+	w = routedRequest(s, "GET", "/large", map[string]string{"Accept-Encoding": "br, gzip;q=0"})
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, large, w.Body.String())
+	w = routedRequest(s, "GET", "/small", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Empty(t, w.Header().Get("Content-Encoding"), "responses smaller than compression_min_size are not compressed")
+	assert.Equal(t, "hijacked", w.Body.String())
+	w = routedRequest(s, "GET", "/plain", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, large, w.Body.String())
+
+	compressed := new(bytes.Buffer)
+	gw := gzip.NewWriter(compressed)
+	gw.Write([]byte("name=turbo"))
+	gw.Close()
+	req, _ := http.NewRequest("POST", "/echo", compressed)
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	assert.Equal(t, "name=turbo", w.Body.String())
+	req, _ = http.NewRequest("POST", "/echo", strings.NewReader("name=turbo"))
+	req.Header.Set("Content-Encoding", "br")
+	w = httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestCompressionEncodings(t *testing.T) {
+	c := NewConfigFromMap("grpc", map[string]string{httpPort: "8081", compressionEncodings: "br,gzip"})
+	_, err := c.compressionPolicy()
+	assert.Equal(t, "turbo: unknown encodings in [compression_encodings]: br, register them with RegisterEncoding()", err.Error())
+
+	RegisterEncoding(Encoding{Name: "br", NewWriter: encodings["gzip"].NewWriter, NewReader: encodings["gzip"].NewReader})
+	defer delete(encodings, "br")
+	p, err := c.compressionPolicy()
+	assert.Nil(t, err)
+	assert.Equal(t, 1024, p.MinSize)
+	e, ok := p.negotiate("gzip, br")
+	assert.True(t, ok)
+	assert.Equal(t, "br", e.Name)
+	_, ok = p.negotiate("identity")
+	assert.False(t, ok)
+}
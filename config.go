@@ -1,28 +1,81 @@
 package turbo
 
 import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	grpcServiceName               = "grpc_service_name"
 	grpcServiceHost               = "grpc_service_host"
 	grpcServicePort               = "grpc_service_port"
+	grpcTransport                 = "grpc_transport"
+	grpcServiceAddress            = "grpc_service_address"
 	thriftServiceName             = "thrift_service_name"
 	thriftServiceHost             = "thrift_service_host"
 	thriftServicePort             = "thrift_service_port"
+	thriftServiceAddress          = "thrift_service_address"
+	thriftMaxConnections          = "thrift_max_connections"
+	thriftWorkers                 = "thrift_workers"
+	thriftReadTimeout             = "thrift_read_timeout"
 	httpPort                      = "http_port"
+	httpAddress                   = "http_address"
+	unixSocketMode                = "unix_socket_mode"
+	adminPort                     = "admin_port"
+	adminAddress                  = "admin_address"
+	shutdownDrainPeriod           = "shutdown_drain_period"
+	shutdownTimeout               = "shutdown_timeout"
+	upgradeTimeout                = "upgrade_timeout"
 	filterProtoJson               = "filter_proto_json"
 	filterProtoJsonEmitZeroValues = "filter_proto_json_emit_zerovalues"
 	filterProtoJsonInt64AsNumber  = "filter_proto_json_int64_as_number"
 	turboLogPath                  = "turbo_log_path"
+	logLevel                      = "log_level"
+	logFormat                     = "log_format"
+	logOutput                     = "log_output"
+	logRotateMaxSize              = "log_rotate_max_size"
+	logRotateInterval             = "log_rotate_interval"
+	logRotateMaxBackups           = "log_rotate_max_backups"
+	logRotateMaxAge               = "log_rotate_max_age"
+	logSyslogNetwork              = "log_syslog_network"
+	logSyslogAddress              = "log_syslog_address"
+	logSyslogTag                  = "log_syslog_tag"
 	environment                   = "environment"
 	serviceRootPath               = "service_root_path"
+	cacheSize                     = "cache_size"
+	authJWTJWKSFile               = "auth_jwt_jwks_file"
+	authJWTPublicKeyFile          = "auth_jwt_public_key_file"
+	authJWTHMACSecret             = "auth_jwt_hmac_secret"
+	authJWTIssuer                 = "auth_jwt_issuer"
+	authJWTAudience               = "auth_jwt_audience"
+	authAPIKeyFile                = "auth_api_key_file"
+	authAPIKeyHeader              = "auth_api_key_header"
+	authBasicFile                 = "auth_basic_file"
+	authBasicRealm                = "auth_basic_realm"
+	authClaimFields               = "auth_claim_fields"
+	httpReadTimeout               = "http_read_timeout"
+	httpReadHeaderTimeout         = "http_read_header_timeout"
+	httpWriteTimeout              = "http_write_timeout"
+	httpIdleTimeout               = "http_idle_timeout"
+	httpMaxHeaderBytes            = "http_max_header_bytes"
+	maxBodySize                   = "max_body_size"
+	maxConcurrentRequests         = "max_concurrent_requests"
+	compression                   = "compression"
+	compressionMinSize            = "compression_min_size"
+	compressionEncodings          = "compression_encodings"
 
 	urlServiceMaps = "urlServiceMaps"
 	interceptors   = "interceptors"
@@ -30,6 +83,9 @@ const (
 	postprocessors = "postprocessors"
 	hijackers      = "hijackers"
 	convertors     = "convertors"
+
+	// envPrefix is the prefix of environment variables which override values in "config", e.g. TURBO_HTTP_PORT
+	envPrefix = "TURBO_"
 )
 
 // GOPATH inits the GOPATH turbo used.
@@ -39,98 +95,414 @@ func GOPATH() string {
 	return paths[0]
 }
 
-// RpcType should be "grpc" or "thrift"
+// RpcType should be "grpc" or "thrift", it's the rpc type of the config loaded last,
+// use Config.RpcType() when more than one server runs in a process.
 var RpcType string
 
 // Config holds the info in a config file
 type Config struct {
 	viper.Viper
-	// File is the config file path
-	File          string
+	// File is the config file path, or the name of Source
+	File string
+	// Source provides the config content, config is read from File if it's nil
+	Source        ConfigSource
 	configs       map[string]string
 	fieldMappings map[string][]string
 	mappings      map[string][][3]string
+	// timeouts holds "timeout" of routes, keyed by "METHODS PATH"
+	timeouts map[string]time.Duration
+	// caches holds cache policies of GET routes, keyed by "METHODS PATH"
+	caches map[string]CachePolicy
+	// coalesced holds routes with request coalescing enabled, keyed by "METHODS PATH"
+	coalesced map[string]bool
+	// cors holds CORS policies of url patterns, keyed by path
+	cors map[string]*CORSPolicy
+	// authz holds authorization rules in "authorization" and routes
+	authz []AuthzRule
+	// bodyLimits holds the max size of request bodies of routes, keyed by "METHODS PATH"
+	bodyLimits map[string]int64
+	// compressed holds routes with compression turned on or off, keyed by "METHODS PATH"
+	compressed map[string]bool
+	// contents holds the config content read, for reporting the position of an invalid entry
+	contents []namedContent
+	// rpcType is "grpc" or "thrift"
+	rpcType string
+}
+
+type namedContent struct {
+	name string
+	data []byte
 }
 
 // NewConfig loads the config file at 'configFilePath', and returns a Config struct ptr
 func NewConfig(rpcType, configFilePath string) *Config {
 	RpcType = rpcType
-	c := &Config{
-		Viper:    *viper.New(),
-		File:     configFilePath,
-		mappings: make(map[string][][3]string)}
+	c := newConfig(rpcType, configFilePath, nil)
+	c.loadServiceConfig()
+	return c
+}
+
+// NewConfigFromSource loads config from 'source', and returns a Config struct ptr
+func NewConfigFromSource(rpcType string, source ConfigSource) *Config {
+	RpcType = rpcType
+	c := newConfig(rpcType, source.Name(), source)
 	c.loadServiceConfig()
 	return c
 }
 
+func newConfig(rpcType, file string, source ConfigSource) *Config {
+	return &Config{
+		Viper:      *viper.New(),
+		File:       file,
+		rpcType:    rpcType,
+		Source:     source,
+		mappings:   make(map[string][][3]string),
+		timeouts:   make(map[string]time.Duration),
+		caches:     make(map[string]CachePolicy),
+		coalesced:  make(map[string]bool),
+		cors:       make(map[string]*CORSPolicy),
+		bodyLimits: make(map[string]int64),
+		compressed: make(map[string]bool)}
+}
+
 func (c *Config) ErrorHandler() string {
 	return c.GetString("errorhandler")
 }
 
+// GlobalInterceptors returns names in "global_interceptor", they run on all URLs,
+// before interceptors of groups and routes.
+func (c *Config) GlobalInterceptors() []string {
+	names, err := parseNames(c.Get("global_interceptor"))
+	if err != nil {
+		panic("[global_interceptor] should be a list of names, got: " + fmt.Sprint(c.Get("global_interceptor")))
+	}
+	return names
+}
+
+// InterceptorPriorities returns priorities in "interceptor_priority", lines like "AuthInterceptor 10",
+// interceptors with higher priorities run Before() earlier, and After() later, defaults to 0.
+func (c *Config) InterceptorPriorities() map[string]int {
+	priorities := make(map[string]int)
+	for i, line := range c.GetStringSlice("interceptor_priority") {
+		values := strings.Fields(line)
+		if len(values) != 2 {
+			panic(fmt.Sprintf("%s: invalid [interceptor_priority] %q, should be \"NAME PRIORITY\"",
+				c.position("interceptor_priority", i, line), line))
+		}
+		p, err := strconv.Atoi(values[1])
+		if err != nil {
+			panic(fmt.Sprintf("%s: invalid [interceptor_priority] %q, priority should be an integer",
+				c.position("interceptor_priority", i, line), line))
+		}
+		priorities[values[0]] = p
+	}
+	return priorities
+}
+
+// loadServiceConfig loads config in layers, a latter layer takes precedence over the former ones:
+// 1, the config file at c.File, or the content read from c.Source
+// 2, the overlay file for the environment, e.g. "service.production.yaml" next to "service.yaml" (files only)
+// 3, environment variables like TURBO_HTTP_PORT, which override "http_port" in "config"
+// 4, values set by OverrideConfig() or ConfigFlag(), e.g. "-config http_port=8081"
+// ${VAR} in values of config files is replaced with environment variable VAR.
 func (c *Config) loadServiceConfig() {
-	c.SetConfigFile(c.File)
-	err := c.ReadInConfig()
-	panicIf(err)
+	if c.Source != nil {
+		c.readSource()
+	} else {
+		c.readFile()
+	}
 	c.loadUrlMap()
 	c.loadConfigs()
 	c.loadComponents()
 }
 
+func (c *Config) readFile() {
+	c.SetConfigFile(c.File)
+	// config files are read as yaml, after values in them are interpolated
+	c.SetConfigType("yaml")
+	panicIf(c.ReadConfig(bytes.NewReader(c.readConfigFile(c.File))))
+	if overlay := c.overlayFile(); len(overlay) > 0 {
+		panicIf(c.MergeConfig(bytes.NewReader(c.readConfigFile(overlay))))
+	}
+}
+
+func (c *Config) readConfigFile(file string) []byte {
+	content, err := ioutil.ReadFile(file)
+	panicIf(err)
+	c.contents = append(c.contents, namedContent{name: file, data: content})
+	return interpolate(content)
+}
+
+var matchEnvVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolate parses content as yaml, and replaces ${VAR} in string values with the value of environment variable VAR,
+// and ${VAR:-default} with "default" if VAR is not set, it panics if VAR is not set and has no default.
+// Comments are not interpolated, and replaced values are kept as strings, even if they contain ':' or newlines.
+func interpolate(content []byte) []byte {
+	var doc interface{}
+	panicIf(yaml.Unmarshal(content, &doc))
+	if doc == nil {
+		return content
+	}
+	missing := make([]string, 0)
+	result, err := yaml.Marshal(interpolateValue(doc, &missing))
+	panicIf(err)
+	if len(missing) > 0 {
+		panic("environment variable not set: " + strings.Join(missing, ", "))
+	}
+	return result
+}
+
+func interpolateValue(v interface{}, missing *[]string) interface{} {
+	switch value := v.(type) {
+	case string:
+		return matchEnvVar.ReplaceAllStringFunc(value, func(m string) string {
+			sub := matchEnvVar.FindStringSubmatch(m)
+			if env, ok := os.LookupEnv(sub[1]); ok {
+				return env
+			}
+			if len(sub[2]) > 0 {
+				return sub[3]
+			}
+			*missing = append(*missing, sub[1])
+			return m
+		})
+	case map[interface{}]interface{}:
+		for k, item := range value {
+			value[k] = interpolateValue(item, missing)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = interpolateValue(item, missing)
+		}
+	}
+	return v
+}
+
+// overlayFile returns the path to the overlay file for the environment if it exists,
+// the environment is read from overrides first, then from environment variable TURBO_ENVIRONMENT,
+// then from the config file, in the same precedence as the values in "config".
+func (c *Config) overlayFile() string {
+	env, ok := overrides()[environment]
+	if !ok {
+		env, ok = os.LookupEnv(envPrefix + strings.ToUpper(environment))
+	}
+	if !ok {
+		env = c.GetString("config." + environment)
+	}
+	if len(strings.TrimSpace(env)) == 0 {
+		return ""
+	}
+	ext := filepath.Ext(c.File)
+	overlay := strings.TrimSuffix(c.File, ext) + "." + strings.TrimSpace(env) + ext
+	if _, err := os.Stat(overlay); err != nil {
+		return ""
+	}
+	return overlay
+}
+
 func (c *Config) loadComponents() {
 	c.mappings[interceptors] = c.loadMappings("interceptor")
 	c.mappings[preprocessors] = c.loadMappings("preprocessor")
 	c.mappings[postprocessors] = c.loadMappings("postprocessor")
 	c.mappings[hijackers] = c.loadMappings("hijacker")
 	c.mappings[convertors] = c.loadConvertor()
+	c.loadCaches()
+	c.loadCoalesce()
+	c.loadBodyLimits()
+	c.loadCompress()
+	c.loadCORS()
+	c.loadAuthorization()
+	c.loadRoutes()
+	// parse them on loading, so that errors are reported early
+	c.GlobalInterceptors()
+	c.InterceptorPriorities()
+	c.GlobalCORS()
+	c.AuthClaimFields()
+	c.MaxBodySize()
+	c.MaxConcurrentRequests()
+	c.CompressionMinSize()
+	c.GrpcTransport()
+	c.UnixSocketMode()
+	c.ThriftInterceptors()
+	c.ThriftMaxConnections()
+	c.ThriftWorkers()
+	c.ThriftReadTimeout()
 }
 
 func (c *Config) loadUrlMap() {
 	c.mappings[urlServiceMaps] = c.loadMappings("urlmapping")
 }
 
+// loadMappings loads lines like "GET,POST /hello SayHello" in config,
+// it panics with the position of the line if it doesn't have exactly 3 columns.
 func (c *Config) loadMappings(key string) [][3]string {
 	mapping := make([][3]string, 0)
-	lines := c.GetStringSlice(key)
-	for _, line := range lines {
-		mapping = appendMap(mapping, strings.TrimSpace(line))
+	for i, line := range c.GetStringSlice(key) {
+		values := strings.Fields(line)
+		if len(values) != 3 {
+			panic(fmt.Sprintf("%s: invalid [%s] %q, should be \"METHODS PATH NAME\"", c.position(key, i, line), key, line))
+		}
+		mapping = append(mapping, [3]string{values[0], values[1], values[2]})
 	}
 	return mapping
 }
 
-func appendMap(mapping [][3]string, line string) [][3]string {
-	values := strings.Split(line, " ")
-	HTTPMethod := strings.TrimSpace(values[0])
-	url := strings.TrimSpace(values[1])
-	value := strings.TrimSpace(values[2])
-	return append(mapping, [3]string{HTTPMethod, url, value})
-}
-
+// loadConvertor loads lines like "CommonValues convertor" in config
 func (c *Config) loadConvertor() [][3]string {
 	mapping := make([][3]string, 0)
-	lines := c.GetStringSlice("convertor")
-	for _, line := range lines {
-		values := strings.Split(strings.TrimSpace(line), " ")
-		name := strings.TrimSpace(values[0])
-		convertorName := strings.TrimSpace(values[1])
-		mapping = append(mapping, [3]string{name, convertorName})
+	for i, line := range c.GetStringSlice("convertor") {
+		values := strings.Fields(line)
+		if len(values) != 2 {
+			panic(fmt.Sprintf("%s: invalid [convertor] %q, should be \"TYPE NAME\"", c.position("convertor", i, line), line))
+		}
+		mapping = append(mapping, [3]string{values[0], values[1]})
 	}
 	return mapping
 }
 
+// loadCaches loads lines like "GET /hello 30s" or "GET /hello 30s Accept-Language,X-Tenant" in "cache",
+// responses of the route are cached for the TTL, headers in the 4th column are part of the cache key.
+func (c *Config) loadCaches() {
+	for i, line := range c.GetStringSlice("cache") {
+		values := strings.Fields(line)
+		if len(values) != 3 && len(values) != 4 {
+			panic(fmt.Sprintf("%s: invalid [cache] %q, should be \"METHODS PATH TTL [HEADERS]\"", c.position("cache", i, line), line))
+		}
+		ttl, err := time.ParseDuration(values[2])
+		if err != nil || ttl <= 0 {
+			panic(fmt.Sprintf("%s: invalid [cache] %q, TTL should be a duration like \"30s\"", c.position("cache", i, line), line))
+		}
+		p := CachePolicy{TTL: ttl}
+		if len(values) == 4 {
+			p.Headers, _ = parseNames(values[3])
+		}
+		c.caches[values[0]+" "+values[1]] = p
+	}
+}
+
+// routeCache returns the cache policy of the route with methods and path, false if it's not cached
+func (c *Config) routeCache(methods, path string) (CachePolicy, bool) {
+	p, ok := c.caches[methods+" "+path]
+	return p, ok
+}
+
+// loadCoalesce loads lines like "GET /hello" in "coalesce", identical concurrent calls of the route
+// are collapsed into one backend call.
+func (c *Config) loadCoalesce() {
+	for i, line := range c.GetStringSlice("coalesce") {
+		values := strings.Fields(line)
+		if len(values) != 2 {
+			panic(fmt.Sprintf("%s: invalid [coalesce] %q, should be \"METHODS PATH\"", c.position("coalesce", i, line), line))
+		}
+		c.coalesced[values[0]+" "+values[1]] = true
+	}
+}
+
+// position returns "file:line" of the line which is text in section key of the config content read,
+// a leading "- " and quotes around the line are ignored, "[key] #n" is returned if it's not found, n is 1-based.
+func (c *Config) position(key string, index int, text string) string {
+	text = strings.TrimSpace(text)
+	pos := c.find(key, func(line string) bool { return len(text) > 0 && line == text })
+	if len(pos) > 0 {
+		return pos
+	}
+	return "[" + key + "] #" + strconv.Itoa(index+1)
+}
+
+// find returns "file:line" of the first line in section key which matches,
+// a leading "- " and quotes around lines are trimmed before matching, "" is returned if it's not found.
+func (c *Config) find(key string, match func(line string) bool) string {
+	for _, content := range c.contents {
+		inSection := false
+		for n, line := range strings.Split(string(content.data), "\n") {
+			if len(line) > 0 && line[0] != ' ' && line[0] != '\t' && line[0] != '-' && line[0] != '#' {
+				inSection = strings.HasPrefix(line, key+":")
+			}
+			line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "-"))
+			if inSection && match(strings.Trim(line, `"'`)) {
+				return content.name + ":" + strconv.Itoa(n+1)
+			}
+		}
+	}
+	return ""
+}
+
 func (c *Config) loadConfigs() {
 	c.configs = c.GetStringMapString("config")
+	if c.configs == nil {
+		c.configs = make(map[string]string)
+	}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		kv = strings.TrimPrefix(kv, envPrefix)
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			continue
+		}
+		c.configs[strings.ToLower(kv[:i])] = kv[i+1:]
+	}
+	for k, v := range overrides() {
+		c.configs[k] = v
+	}
+}
+
+var configOverrides = struct {
+	sync.RWMutex
+	values map[string]string
+}{values: make(map[string]string)}
+
+// OverrideConfig overrides a value in "config", it takes precedence over config files and environment variables,
+// and is kept when config is reloaded.
+func OverrideConfig(key, value string) {
+	configOverrides.Lock()
+	defer configOverrides.Unlock()
+	configOverrides.values[strings.ToLower(strings.TrimSpace(key))] = value
+}
+
+func overrides() map[string]string {
+	configOverrides.RLock()
+	defer configOverrides.RUnlock()
+	values := make(map[string]string, len(configOverrides.values))
+	for k, v := range configOverrides.values {
+		values[k] = v
+	}
+	return values
+}
+
+type configFlag struct{}
+
+func (configFlag) String() string { return "" }
+
+func (configFlag) Set(kv string) error {
+	i := strings.Index(kv, "=")
+	if i <= 0 {
+		return errors.New("should be key=value, got: " + kv)
+	}
+	OverrideConfig(kv[:i], kv[i+1:])
+	return nil
+}
+
+func (configFlag) Type() string { return "key=value" }
+
+// ConfigFlag returns a flag.Value which calls OverrideConfig() with "key=value", it can be set multiple times, e.g.
+// flag.Var(turbo.ConfigFlag(), "config", "override a value in config, e.g. http_port=8081")
+func ConfigFlag() flag.Value {
+	return configFlag{}
 }
 
 var matchKey = regexp.MustCompile("^(.*)\\[")
 var matchSlice = regexp.MustCompile("\\[(.+)\\]")
 
 func (c *Config) loadFieldMapping() {
-	c.SetConfigName(RpcType + "fields")
+	c.SetConfigName(c.rpcType + "fields")
 	c.AddConfigPath(c.ServiceRootPathAbsolute() + "/gen")
 	err := c.ReadInConfig()
 	panicIf(err)
 	c.fieldMappings = make(map[string][]string)
-	mappings := c.GetStringSlice(RpcType + "-fieldmapping")
+	mappings := c.GetStringSlice(c.rpcType + "-fieldmapping")
 	for _, m := range mappings {
 		keyStr := matchKey.FindStringSubmatch(m)
 		key := m
@@ -156,6 +528,11 @@ func parseSliceStr(valueSliceStr []string) []string {
 	return result
 }
 
+// RpcType returns the rpc type the config is loaded with, "grpc" or "thrift"
+func (c *Config) RpcType() string {
+	return c.rpcType
+}
+
 func (c *Config) Env() string {
 	return c.configs[environment]
 }
@@ -193,6 +570,20 @@ func (c *Config) GrpcServicePort() string {
 	return c.configs[grpcServicePort]
 }
 
+// GrpcTransport returns "grpc_transport" in config file, "tcp" or "inprocess", defaults to "tcp".
+// With "inprocess", StartGRPC() doesn't listen on "grpc_service_port", the HTTP server calls
+// the grpc service through an in-memory connection instead.
+func (c *Config) GrpcTransport() string {
+	switch t := strings.TrimSpace(c.configs[grpcTransport]); t {
+	case "":
+		return transportTCP
+	case transportTCP, transportInProcess:
+		return t
+	default:
+		panic("[grpc_transport] should be \"" + transportTCP + "\" or \"" + transportInProcess + "\", got: " + t)
+	}
+}
+
 func (c *Config) ThriftServiceName() string {
 	return c.configs[thriftServiceName]
 }
@@ -215,6 +606,47 @@ func (c *Config) HTTPPort() int64 {
 	return i
 }
 
+// AdminPort returns "admin_port" in config file, the admin HTTP server is not started if it's not set
+func (c *Config) AdminPort() int64 {
+	return c.intValue(adminPort)
+}
+
+// AdminAddress returns "admin_address" in config file, e.g. "unix:///var/run/turbo-admin.sock", or "0.0.0.0:9090",
+// the admin HTTP server listens on it, it defaults to "127.0.0.1:[admin_port]", since the admin server has no
+// authentication, and is able to reload config.
+// The admin server is not started if neither "admin_address" nor "admin_port" is set.
+func (c *Config) AdminAddress() string {
+	if addr := strings.TrimSpace(c.configs[adminAddress]); len(addr) > 0 {
+		return addr
+	}
+	if port := c.AdminPort(); port != 0 {
+		return "127.0.0.1:" + strconv.FormatInt(port, 10)
+	}
+	return ""
+}
+
+// ShutdownDrainPeriod returns "shutdown_drain_period" in config file, e.g. "10s",
+// on shutdown, readiness turns to false first, and servers keep serving for this period
+// before they stop accepting new connections, so that load balancers have time to notice.
+func (c *Config) ShutdownDrainPeriod() time.Duration {
+	return c.durationValue(shutdownDrainPeriod, 0)
+}
+
+// ShutdownTimeout returns "shutdown_timeout" in config file, defaults to "5s",
+// it's the max time to wait for in-flight requests to complete after servers stop accepting new connections.
+func (c *Config) ShutdownTimeout() time.Duration {
+	return c.durationValue(shutdownTimeout, time.Second*5)
+}
+
+// CacheSize returns "cache_size" in config file, the max number of responses in the in-memory cache,
+// defaults to 1000.
+func (c *Config) CacheSize() int64 {
+	if size := c.intValue(cacheSize); size > 0 {
+		return size
+	}
+	return defaultCacheSize
+}
+
 func (c *Config) FilterProtoJson() bool {
 	option, ok := c.configs[filterProtoJson]
 	if !ok || option != "true" {
@@ -246,3 +678,112 @@ func (c *Config) FilterProtoJsonInt64AsNumber() bool {
 	}
 	return true
 }
+
+// LogLevel returns "log_level" in config file, one of "panic", "fatal", "error", "warn", "info" and "debug",
+// defaults to "info" in production environment, and "debug" otherwise.
+func (c *Config) LogLevel() string {
+	if l := strings.TrimSpace(c.configs[logLevel]); len(l) > 0 {
+		return strings.ToLower(l)
+	}
+	if c.Env() == "production" {
+		return "info"
+	}
+	return "debug"
+}
+
+// LogFormat returns "log_format" in config file, "json" or "text",
+// defaults to "json" in production environment, and "text" otherwise.
+func (c *Config) LogFormat() string {
+	if f := strings.TrimSpace(c.configs[logFormat]); len(f) > 0 {
+		return strings.ToLower(f)
+	}
+	if c.Env() == "production" {
+		return "json"
+	}
+	return "text"
+}
+
+// LogOutputs returns a list of log outputs in "log_output", e.g. "stdout,file",
+// valid outputs are "stdout", "stderr", "file" and "syslog",
+// defaults to "file" in production environment, and "stderr" otherwise.
+func (c *Config) LogOutputs() []string {
+	outputs := make([]string, 0)
+	for _, o := range strings.Split(c.configs[logOutput], ",") {
+		if o = strings.ToLower(strings.TrimSpace(o)); len(o) > 0 {
+			outputs = append(outputs, o)
+		}
+	}
+	if len(outputs) > 0 {
+		return outputs
+	}
+	if c.Env() == "production" {
+		return []string{"file"}
+	}
+	return []string{"stderr"}
+}
+
+// LogRotateMaxSize returns "log_rotate_max_size" in megabytes,
+// the log file is rotated when it grows beyond this size, 0 means no size based rotation.
+func (c *Config) LogRotateMaxSize() int64 {
+	return c.intValue(logRotateMaxSize)
+}
+
+// LogRotateInterval returns "log_rotate_interval", "hourly" or "daily",
+// the log file is rotated when a new hour or day(in UTC) begins, "" means no time based rotation.
+func (c *Config) LogRotateInterval() string {
+	return strings.ToLower(strings.TrimSpace(c.configs[logRotateInterval]))
+}
+
+// LogRotateMaxBackups returns "log_rotate_max_backups", the max number of rotated log files to keep,
+// 0 means keeping all of them.
+func (c *Config) LogRotateMaxBackups() int64 {
+	return c.intValue(logRotateMaxBackups)
+}
+
+// LogRotateMaxAge returns "log_rotate_max_age" in days, rotated log files older than this are removed,
+// 0 means never remove log files by age.
+func (c *Config) LogRotateMaxAge() int64 {
+	return c.intValue(logRotateMaxAge)
+}
+
+// LogSyslogNetwork returns "log_syslog_network", e.g. "udp", leave it blank to connect to the local syslog server.
+func (c *Config) LogSyslogNetwork() string {
+	return c.configs[logSyslogNetwork]
+}
+
+// LogSyslogAddress returns "log_syslog_address", e.g. "localhost:514".
+func (c *Config) LogSyslogAddress() string {
+	return c.configs[logSyslogAddress]
+}
+
+// LogSyslogTag returns "log_syslog_tag", defaults to "turbo".
+func (c *Config) LogSyslogTag() string {
+	if t := strings.TrimSpace(c.configs[logSyslogTag]); len(t) > 0 {
+		return t
+	}
+	return "turbo"
+}
+
+func (c *Config) durationValue(key string, defaultValue time.Duration) time.Duration {
+	v := strings.TrimSpace(c.configs[key])
+	if len(v) == 0 {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		panic("[" + key + "] should be a duration like \"5s\", got: " + v)
+	}
+	return d
+}
+
+func (c *Config) intValue(key string) int64 {
+	v := strings.TrimSpace(c.configs[key])
+	if len(v) == 0 {
+		return 0
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		panic("[" + key + "] should be an integer, got: " + v)
+	}
+	return i
+}
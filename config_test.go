@@ -1,9 +1,14 @@
 package turbo
 
 import (
+	"flag"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestConfig(t *testing.T) {
@@ -80,3 +85,225 @@ func TestHttpPortPanic(t *testing.T) {
 	c.configs[httpPort] = ""
 	c.HTTPPort()
 }
+
+func TestLogConfig(t *testing.T) {
+	c := NewConfig("grpc", "test/service_test.yaml")
+	assert.Equal(t, "info", c.LogLevel())
+	assert.Equal(t, "json", c.LogFormat())
+	assert.Equal(t, []string{"file"}, c.LogOutputs())
+	assert.Equal(t, int64(0), c.LogRotateMaxSize())
+	assert.Equal(t, "turbo", c.LogSyslogTag())
+
+	c.configs[environment] = "development"
+	assert.Equal(t, "debug", c.LogLevel())
+	assert.Equal(t, "text", c.LogFormat())
+	assert.Equal(t, []string{"stderr"}, c.LogOutputs())
+
+	c.configs[logLevel] = "WARN"
+	c.configs[logFormat] = "json"
+	c.configs[logOutput] = "stdout, file"
+	c.configs[logRotateMaxSize] = "100"
+	c.configs[logRotateInterval] = "Daily"
+	assert.Equal(t, "warn", c.LogLevel())
+	assert.Equal(t, "json", c.LogFormat())
+	assert.Equal(t, []string{"stdout", "file"}, c.LogOutputs())
+	assert.Equal(t, int64(100), c.LogRotateMaxSize())
+	assert.Equal(t, "daily", c.LogRotateInterval())
+
+	defer func() {
+		if err := recover(); err != nil {
+			assert.Equal(t, "[log_rotate_max_backups] should be an integer, got: ten", err)
+		} else {
+			t.Errorf("The code did not panic")
+		}
+	}()
+	c.configs[logRotateMaxBackups] = "ten"
+	c.LogRotateMaxBackups()
+}
+
+func TestShutdownConfig(t *testing.T) {
+	c := NewConfig("grpc", "test/service_test.yaml")
+	assert.Equal(t, time.Duration(0), c.ShutdownDrainPeriod())
+	assert.Equal(t, time.Second*5, c.ShutdownTimeout())
+
+	c.configs[shutdownDrainPeriod] = "10s"
+	c.configs[shutdownTimeout] = "1m"
+	assert.Equal(t, time.Second*10, c.ShutdownDrainPeriod())
+	assert.Equal(t, time.Minute, c.ShutdownTimeout())
+
+	defer func() {
+		if err := recover(); err != nil {
+			assert.Equal(t, `[shutdown_timeout] should be a duration like "5s", got: 10`, err)
+		} else {
+			t.Errorf("The code did not panic")
+		}
+	}()
+	c.configs[shutdownTimeout] = "10"
+	c.ShutdownTimeout()
+}
+
+func TestConfigLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "turbo_config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	base := `config:
+  environment: ${TEST_TURBO_ENV}
+  http_port: 8081
+  grpc_service_host: ${TEST_TURBO_HOST:-127.0.0.1}
+  grpc_service_port: 50051
+  thrift_service_port: 50052
+urlmapping:
+  - GET /hello SayHello
+`
+	overlay := `config:
+  grpc_service_port: 50061
+`
+	assert.Nil(t, ioutil.WriteFile(dir+"/service.yaml", []byte(base), 0644))
+	assert.Nil(t, ioutil.WriteFile(dir+"/service.staging.yaml", []byte(overlay), 0644))
+	os.Setenv("TEST_TURBO_ENV", "staging")
+	os.Setenv("TURBO_HTTP_PORT", "9091")
+	defer os.Unsetenv("TEST_TURBO_ENV")
+	defer os.Unsetenv("TURBO_HTTP_PORT")
+	defer func() { configOverrides.values = make(map[string]string) }()
+
+	c := NewConfig("grpc", dir+"/service.yaml")
+	assert.Equal(t, "staging", c.Env())
+	assert.Equal(t, "127.0.0.1", c.GrpcServiceHost())
+	assert.Equal(t, "50061", c.GrpcServicePort())
+	assert.Equal(t, int64(9091), c.HTTPPort())
+	assert.Equal(t, "SayHello", c.mappings[urlServiceMaps][0][2])
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(ConfigFlag(), "config", "")
+	assert.Nil(t, fs.Parse([]string{"-config", "http_port=9092", "-config", "thrift_service_port=50062"}))
+	assert.NotNil(t, fs.Parse([]string{"-config", "http_port"}))
+	c = NewConfig("grpc", dir+"/service.yaml")
+	assert.Equal(t, int64(9092), c.HTTPPort())
+	assert.Equal(t, "50062", c.ThriftServicePort())
+
+	OverrideConfig("environment", "production")
+	c = NewConfig("grpc", dir+"/service.yaml")
+	assert.Equal(t, "production", c.Env())
+	assert.Equal(t, "50051", c.GrpcServicePort())
+
+	// TURBO_ENVIRONMENT takes precedence over the config file in selecting the overlay
+	configOverrides.values = make(map[string]string)
+	os.Setenv("TEST_TURBO_ENV", "production")
+	os.Setenv("TURBO_ENVIRONMENT", "staging")
+	defer os.Unsetenv("TURBO_ENVIRONMENT")
+	c = NewConfig("grpc", dir+"/service.yaml")
+	assert.Equal(t, "staging", c.Env())
+	assert.Equal(t, "50061", c.GrpcServicePort())
+}
+
+func TestInterpolate(t *testing.T) {
+	os.Setenv("TEST_TURBO_VAR", "value")
+	os.Setenv("TEST_TURBO_COLON", "a: b\nc")
+	defer os.Unsetenv("TEST_TURBO_VAR")
+	defer os.Unsetenv("TEST_TURBO_COLON")
+	content := interpolate([]byte(`# ${TEST_TURBO_UNSET} in comments is ignored
+a: ${TEST_TURBO_VAR}-${TEST_TURBO_UNSET:-default}-${TEST_TURBO_UNSET:-}-$HOME
+list:
+  - ${TEST_TURBO_COLON}
+`))
+	var doc map[string]interface{}
+	assert.Nil(t, yaml.Unmarshal(content, &doc))
+	assert.Equal(t, "value-default--$HOME", doc["a"])
+	assert.Equal(t, []interface{}{"a: b\nc"}, doc["list"])
+	assert.Panics(t, func() { interpolate([]byte("a: ${TEST_TURBO_UNSET}")) })
+}
+
+func writeTestConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "turbo_config")
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(dir+"/service.yaml", []byte(content), 0644))
+	return dir + "/service.yaml", func() { os.RemoveAll(dir) }
+}
+
+func TestRoutes(t *testing.T) {
+	file, remove := writeTestConfig(t, `config:
+  http_port: 8081
+urlmapping:
+  - GET  /hello   SayHello
+routes:
+  - method: get, post
+    path: /apple
+    rpc: EatApple
+    interceptors: [LogInterceptor, AuthInterceptor]
+    preprocessor: preprocessor
+    postprocessor: postprocessor
+    hijacker: hijacker
+    timeout: 3s
+  - method: GET
+    path: /banana
+    rpc: EatBanana
+    interceptors: LogInterceptor,AuthInterceptor
+  - method: [get, POST]
+    path: /cherry
+    rpc: EatCherry
+convertor:
+  - CommonValues   convertor
+`)
+	defer remove()
+	c := NewConfig("grpc", file)
+	assert.Equal(t, [][3]string{{"GET", "/hello", "SayHello"}, {"GET,POST", "/apple", "EatApple"}, {"GET", "/banana", "EatBanana"},
+		{"GET,POST", "/cherry", "EatCherry"}}, c.mappings[urlServiceMaps])
+	assert.Equal(t, [][3]string{{"GET,POST", "/apple", "LogInterceptor,AuthInterceptor"}, {"GET", "/banana", "LogInterceptor,AuthInterceptor"}},
+		c.mappings[interceptors])
+	assert.Equal(t, [][3]string{{"GET,POST", "/apple", "preprocessor"}}, c.mappings[preprocessors])
+	assert.Equal(t, [][3]string{{"GET,POST", "/apple", "postprocessor"}}, c.mappings[postprocessors])
+	assert.Equal(t, [][3]string{{"GET,POST", "/apple", "hijacker"}}, c.mappings[hijackers])
+	assert.Equal(t, [][3]string{{"CommonValues", "convertor"}}, c.mappings[convertors])
+	assert.Equal(t, time.Second*3, c.routeTimeout("GET,POST", "/apple"))
+	assert.Equal(t, time.Duration(0), c.routeTimeout("GET", "/banana"))
+}
+
+func TestInvalidRoutes(t *testing.T) {
+	cases := []struct{ content, err string }{
+		{"urlmapping:\n  - GET /hello SayHello\n  - GET /hello\n",
+			`:3: invalid [urlmapping] "GET /hello", should be "METHODS PATH NAME"`},
+		{"urlmapping:\n  - GET /hello SayHello\ninterceptor:\n  - GET /hello\n",
+			`:4: invalid [interceptor] "GET /hello", should be "METHODS PATH NAME"`},
+		{"convertor:\n  - CommonValues\n",
+			`:2: invalid [convertor] "CommonValues", should be "TYPE NAME"`},
+		{"routes:\n  - method: GET\n    path: /hello\n    rpc: SayHello\n  - method: GET\n    path: /apple\n    rcp: EatApple\n",
+			`:6: invalid [routes] #2, unknown keys: rcp, valid keys are: method, path, rpc, interceptors, preprocessor, postprocessor, hijacker, timeout`},
+		{"routes:\n  - method: GET\n    path: hello\n    rpc: SayHello\n",
+			`:3: invalid [routes] #1, [path] should start with '/', got: hello`},
+		{"routes:\n  - path: /hello\n    rpc: SayHello\n",
+			`:2: invalid [routes] #1, [method] is required`},
+		{"routes:\n  - method: {GET: true}\n    path: /hello\n    rpc: SayHello\n",
+			`:3: invalid [routes] #1, [method] should be a list of methods, or methods separated by ','`},
+		{"routes:\n  - method: GET\n    path: /hello\n    rpc: SayHello\n    timeout: 3\n",
+			`:3: invalid [routes] #1, [timeout] should be a duration like "5s", got: 3`},
+		{"routes:\n  - GET /hello SayHello\n",
+			`[routes] #1, should be a map with keys: method, path, rpc, interceptors, preprocessor, postprocessor, hijacker, timeout`},
+	}
+	for _, tc := range cases {
+		file, remove := writeTestConfig(t, tc.content)
+		func() {
+			defer remove()
+			defer func() {
+				err := recover()
+				assert.NotNil(t, err, tc.content)
+				assert.Contains(t, err, tc.err)
+			}()
+			NewConfig("grpc", file)
+		}()
+	}
+}
+
+func TestInterceptorPriorities(t *testing.T) {
+	file, remove := writeTestConfig(t, "global_interceptor: Log, Auth\ninterceptor_priority:\n  - Auth 10\n  - Log -1\n")
+	defer remove()
+	c := NewConfig("grpc", file)
+	assert.Equal(t, []string{"Log", "Auth"}, c.GlobalInterceptors())
+	assert.Equal(t, map[string]int{"Auth": 10, "Log": -1}, c.InterceptorPriorities())
+
+	file, remove = writeTestConfig(t, "interceptor_priority:\n  - Auth high\n")
+	defer remove()
+	defer func() {
+		assert.Equal(t, file+`:2: invalid [interceptor_priority] "Auth high", priority should be an integer`, recover())
+	}()
+	NewConfig("grpc", file)
+}
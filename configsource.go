@@ -0,0 +1,316 @@
+package turbo
+
+import (
+	"bytes"
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ConfigSource provides the content of a config file from somewhere other than a local file,
+// e.g. an HTTP endpoint, a key-value store, or a directory of fragments.
+type ConfigSource interface {
+	// Name identifies the source in logs and errors, e.g. an URL
+	Name() string
+	// Read returns the config content in yaml, if multiple fragments are returned,
+	// they are merged in order, a latter fragment takes precedence over the former ones.
+	Read() ([][]byte, error)
+	// Watch calls onChange when the content is changed, it blocks until done is closed.
+	Watch(done <-chan struct{}, onChange func())
+}
+
+// defaultPollInterval is used by sources polling for changes if the interval is not positive
+const defaultPollInterval = time.Second * 30
+
+// pollInterval returns interval, or defaultPollInterval if interval is not positive
+func pollInterval(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return defaultPollInterval
+	}
+	return interval
+}
+
+// HTTPSource reads config from an HTTP endpoint, and polls it for changes
+type HTTPSource struct {
+	URL      string
+	Interval time.Duration
+	Client   *http.Client
+	// Header is sent with every request, e.g. for authentication
+	Header http.Header
+
+	mu      sync.Mutex
+	etag    string
+	content []byte
+}
+
+// NewHTTPSource returns a HTTPSource which polls url every interval, or every 30s if interval is not positive
+func NewHTTPSource(url string, interval time.Duration) *HTTPSource {
+	return &HTTPSource{URL: url, Interval: pollInterval(interval), Client: http.DefaultClient, Header: make(http.Header)}
+}
+
+func (s *HTTPSource) Name() string { return s.URL }
+
+func (s *HTTPSource) Read() ([][]byte, error) {
+	content, _, err := s.fetch(false)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{content}, nil
+}
+
+// fetch gets the content from URL, if conditional is true, "If-None-Match" is sent with the last ETag,
+// changed is false if the server responds 304, or the content is the same as last time.
+func (s *HTTPSource) fetch(conditional bool) (content []byte, changed bool, err error) {
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	s.mu.Lock()
+	etag, last := s.etag, s.content
+	s.mu.Unlock()
+	if conditional && len(etag) > 0 {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return last, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, errors.New("failed to read config from " + s.URL + ", status: " + resp.Status)
+	}
+	content, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	s.mu.Lock()
+	s.etag, s.content = resp.Header.Get("ETag"), content
+	s.mu.Unlock()
+	return content, !bytes.Equal(content, last), nil
+}
+
+func (s *HTTPSource) Watch(done <-chan struct{}, onChange func()) {
+	ticker := time.NewTicker(pollInterval(s.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, changed, err := s.fetch(true)
+			if err != nil {
+				log.Error("failed to poll config: ", err)
+				continue
+			}
+			if changed {
+				onChange()
+			}
+		}
+	}
+}
+
+// KVStore is a key-value store, e.g. a client of etcd or consul
+type KVStore interface {
+	Get(key string) ([]byte, error)
+}
+
+// KVSource reads config from a key in a KVStore, and polls it for changes
+type KVSource struct {
+	Store    KVStore
+	Key      string
+	Interval time.Duration
+}
+
+// NewKVSource returns a KVSource which polls key in store every interval, or every 30s if interval is not positive
+func NewKVSource(store KVStore, key string, interval time.Duration) *KVSource {
+	return &KVSource{Store: store, Key: key, Interval: pollInterval(interval)}
+}
+
+func (s *KVSource) Name() string { return "kv:" + s.Key }
+
+func (s *KVSource) Read() ([][]byte, error) {
+	content, err := s.Store.Get(s.Key)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{content}, nil
+}
+
+func (s *KVSource) Watch(done <-chan struct{}, onChange func()) {
+	poll(done, pollInterval(s.Interval), s.Read, onChange)
+}
+
+// DirSource reads config from "*.yaml" and "*.yml" files in a directory,
+// fragments are merged in the lexical order of file names, and changes are watched with fsnotify.
+type DirSource struct {
+	Dir string
+}
+
+// NewDirSource returns a DirSource which reads fragments in dir
+func NewDirSource(dir string) *DirSource {
+	return &DirSource{Dir: dir}
+}
+
+func (s *DirSource) Name() string { return s.Dir }
+
+func (s *DirSource) Read() ([][]byte, error) {
+	contents, err := s.readNamed()
+	if err != nil {
+		return nil, err
+	}
+	fragments := make([][]byte, 0, len(contents))
+	for _, content := range contents {
+		fragments = append(fragments, content.data)
+	}
+	return fragments, nil
+}
+
+// readNamed returns fragments with their file paths, so that an invalid entry is reported with its file
+func (s *DirSource) readNamed() ([]namedContent, error) {
+	files := make([]string, 0)
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(s.Dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	contents := make([]namedContent, 0, len(files))
+	for _, f := range files {
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, namedContent{name: f, data: content})
+	}
+	return contents, nil
+}
+
+func (s *DirSource) Watch(done <-chan struct{}, onChange func()) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error("failed to watch config dir: ", err)
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(s.Dir); err != nil {
+		log.Error("failed to watch config dir: ", err)
+		return
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case e := <-watcher.Events:
+			if ext := filepath.Ext(e.Name); ext == ".yaml" || ext == ".yml" {
+				onChange()
+			}
+		case err := <-watcher.Errors:
+			log.Error("failed to watch config dir: ", err)
+		}
+	}
+}
+
+// StaticSource is a ConfigSource with fixed content, e.g. for tests
+type StaticSource struct {
+	name    string
+	content []byte
+}
+
+// NewStaticSource returns a StaticSource with yaml content
+func NewStaticSource(name string, content []byte) *StaticSource {
+	return &StaticSource{name: name, content: content}
+}
+
+func (s *StaticSource) Name() string { return s.name }
+
+func (s *StaticSource) Read() ([][]byte, error) { return [][]byte{s.content}, nil }
+
+// Watch blocks until done is closed, the content never changes
+func (s *StaticSource) Watch(done <-chan struct{}, onChange func()) { <-done }
+
+// NewConfigFromMap returns a Config with values in "config", and without any mappings,
+// routes can be added by Server.AddRoute(), it's useful in tests which don't need a config file.
+func NewConfigFromMap(rpcType string, configs map[string]string) *Config {
+	keys := make([]string, 0, len(configs))
+	for k := range configs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	b.WriteString("config:\n")
+	for _, k := range keys {
+		b.WriteString("  " + k + ": " + strconv.Quote(configs[k]) + "\n")
+	}
+	return NewConfigFromSource(rpcType, NewStaticSource("map", b.Bytes()))
+}
+
+// poll calls read every interval, and calls onChange if the content is changed
+func poll(done <-chan struct{}, interval time.Duration, read func() ([][]byte, error), onChange func()) {
+	last, _ := read()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			fragments, err := read()
+			if err != nil {
+				log.Error("failed to poll config: ", err)
+				continue
+			}
+			if !bytes.Equal(bytes.Join(fragments, []byte{0}), bytes.Join(last, []byte{0})) {
+				last = fragments
+				onChange()
+			}
+		}
+	}
+}
+
+// namedSource is a ConfigSource whose fragments have their own names, e.g. files in a DirSource
+type namedSource interface {
+	readNamed() ([]namedContent, error)
+}
+
+// readSource reads config from c.Source, and merges fragments in order
+func (c *Config) readSource() {
+	var contents []namedContent
+	if ns, ok := c.Source.(namedSource); ok {
+		var err error
+		contents, err = ns.readNamed()
+		panicIf(err)
+	} else {
+		fragments, err := c.Source.Read()
+		panicIf(err)
+		for _, f := range fragments {
+			contents = append(contents, namedContent{name: c.Source.Name(), data: f})
+		}
+	}
+	if len(contents) == 0 {
+		panic("no config read from " + c.Source.Name())
+	}
+	c.SetConfigType("yaml")
+	for i, content := range contents {
+		c.contents = append(c.contents, content)
+		f := interpolate(content.data)
+		if i == 0 {
+			panicIf(c.ReadConfig(bytes.NewReader(f)))
+		} else {
+			panicIf(c.MergeConfig(bytes.NewReader(f)))
+		}
+	}
+}
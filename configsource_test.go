@@ -0,0 +1,181 @@
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func sourceContent(port int) []byte {
+	return []byte(fmt.Sprintf(`config:
+  http_port: %d
+  grpc_service_host: 127.0.0.1
+  grpc_service_port: 50051
+urlmapping:
+  - GET /hello SayHello
+`, port))
+}
+
+func TestHTTPSource(t *testing.T) {
+	var mu sync.Mutex
+	content, etag := sourceContent(8081), "v1"
+	var notModified int32
+	ts := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "secret", req.Header.Get("X-Token"))
+		if req.Header.Get("If-None-Match") == etag {
+			atomic.AddInt32(&notModified, 1)
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+		resp.Header().Set("ETag", etag)
+		resp.Write(content)
+	}))
+	defer ts.Close()
+
+	source := NewHTTPSource(ts.URL, time.Millisecond*10)
+	source.Header.Set("X-Token", "secret")
+	c := NewConfigFromSource("grpc", source)
+	assert.Equal(t, ts.URL, c.File)
+	assert.Equal(t, int64(8081), c.HTTPPort())
+	assert.Equal(t, "SayHello", c.mappings[urlServiceMaps][0][2])
+
+	done := make(chan struct{})
+	changed := make(chan struct{}, 1)
+	go source.Watch(done, func() { changed <- struct{}{} })
+	defer close(done)
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&notModified) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.True(t, atomic.LoadInt32(&notModified) > 0, "the source should be polled")
+	select {
+	case <-changed:
+		t.Fatal("should not be changed")
+	default:
+	}
+
+	mu.Lock()
+	content, etag = sourceContent(8082), "v2"
+	mu.Unlock()
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change not detected")
+	}
+	c = NewConfigFromSource("grpc", source)
+	assert.Equal(t, int64(8082), c.HTTPPort())
+}
+
+func TestHTTPSourceError(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	_, err := NewHTTPSource(ts.URL, time.Second).Read()
+	assert.Equal(t, errors.New("failed to read config from "+ts.URL+", status: 404 Not Found"), err)
+}
+
+type mapStore struct {
+	sync.Mutex
+	values map[string][]byte
+}
+
+func (s *mapStore) Get(key string) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, ok := s.values[key]
+	if !ok {
+		return nil, errors.New("no such key: " + key)
+	}
+	return v, nil
+}
+
+func TestKVSource(t *testing.T) {
+	store := &mapStore{values: map[string][]byte{"service": sourceContent(8081)}}
+	source := NewKVSource(store, "service", time.Millisecond*10)
+	assert.Equal(t, int64(8081), NewConfigFromSource("grpc", source).HTTPPort())
+
+	done := make(chan struct{})
+	changed := make(chan struct{}, 1)
+	go source.Watch(done, func() { changed <- struct{}{} })
+	defer close(done)
+	time.Sleep(time.Millisecond * 30)
+	store.Lock()
+	store.values["service"] = sourceContent(8082)
+	store.Unlock()
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change not detected")
+	}
+	assert.Equal(t, int64(8082), NewConfigFromSource("grpc", source).HTTPPort())
+
+	_, err := NewKVSource(store, "no_such_key", time.Second).Read()
+	assert.NotNil(t, err)
+}
+
+func TestPollInterval(t *testing.T) {
+	assert.Equal(t, defaultPollInterval, NewHTTPSource("http://127.0.0.1/config", 0).Interval)
+	assert.Equal(t, defaultPollInterval, NewKVSource(nil, "service", -time.Second).Interval)
+	assert.Equal(t, time.Second, NewKVSource(nil, "service", time.Second).Interval)
+
+	done := make(chan struct{})
+	close(done)
+	(&KVSource{Store: &mapStore{values: map[string][]byte{}}}).Watch(done, func() {})
+}
+
+func TestDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "turbo_config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(dir+"/00-base.yaml", sourceContent(8081), 0644))
+	assert.Nil(t, ioutil.WriteFile(dir+"/10-port.yml", []byte("config:\n  grpc_service_port: 50061\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(dir+"/README", []byte("not config"), 0644))
+
+	source := NewDirSource(dir)
+	c := NewConfigFromSource("grpc", source)
+	assert.Equal(t, int64(8081), c.HTTPPort())
+	assert.Equal(t, "127.0.0.1", c.GrpcServiceHost())
+	assert.Equal(t, "50061", c.GrpcServicePort())
+	assert.Equal(t, filepath.Join(dir, "10-port.yml")+":2", c.find("config", func(line string) bool { return strings.HasSuffix(line, "50061") }))
+
+	done := make(chan struct{})
+	changed := make(chan struct{}, 1)
+	go source.Watch(done, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer close(done)
+	time.Sleep(time.Millisecond * 50)
+	assert.Nil(t, ioutil.WriteFile(dir+"/20-http.yaml", []byte("config:\n  http_port: 8082\n"), 0644))
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change not detected")
+	}
+	assert.Equal(t, int64(8082), NewConfigFromSource("grpc", source).HTTPPort())
+
+	assert.Panics(t, func() { NewConfigFromSource("grpc", NewDirSource(dir+"/empty")) })
+}
+
+func TestReloadConfigFromSource(t *testing.T) {
+	store := &mapStore{values: map[string][]byte{"service": sourceContent(8081)}}
+	s := &Server{Config: NewConfigFromSource("grpc", NewKVSource(store, "service", time.Second))}
+	s.initChans()
+	store.values["service"] = sourceContent(8082)
+	assert.Nil(t, s.ReloadConfig())
+	c := <-s.reloadConfig
+	assert.Equal(t, int64(8082), c.HTTPPort())
+	assert.Equal(t, s.Config.Source, c.Source)
+}
@@ -0,0 +1,223 @@
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cast"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const corsKey = "cors"
+
+// CORSPolicy is the CORS setting of routes, the global policy is set with "cors_xxx" in "config", e.g.
+//
+//	config:
+//	  cors_allowed_origins: https://a.com,https://b.com
+//	  cors_allow_credentials: true
+//
+// and policies of url patterns are set in "cors", a policy of a pattern replaces the global policy:
+//
+//	cors:
+//	  - path: /hello
+//	    allowed_origins: ["*"]
+//	    allowed_headers: [Content-Type, X-Token]
+//	    max_age: 10m
+type CORSPolicy struct {
+	// AllowedOrigins are origins which are allowed, "*" allows any origin
+	AllowedOrigins []string
+	// AllowedMethods defaults to the methods of the route
+	AllowedMethods []string
+	// AllowedHeaders are request headers which are allowed, "*" allows any header
+	AllowedHeaders []string
+	// ExposedHeaders are response headers which browsers can read
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and authorization headers
+	AllowCredentials bool
+	// MaxAge is how long a preflight response is cached by browsers, 0 means not set
+	MaxAge time.Duration
+}
+
+var corsFields = []string{"allowed_origins", "allowed_methods", "allowed_headers", "exposed_headers",
+	"allow_credentials", "max_age"}
+
+// parseCORSPolicy parses a map with keys in corsFields
+func parseCORSPolicy(m map[string]interface{}) (p *CORSPolicy, err error) {
+	p = new(CORSPolicy)
+	lists := map[string]*[]string{"allowed_origins": &p.AllowedOrigins, "allowed_methods": &p.AllowedMethods,
+		"allowed_headers": &p.AllowedHeaders, "exposed_headers": &p.ExposedHeaders}
+	for key, list := range lists {
+		if *list, err = parseNames(m[key]); err != nil {
+			return nil, fmt.Errorf("[%s] should be a list of names, or names separated by ','", key)
+		}
+	}
+	for i, method := range p.AllowedMethods {
+		p.AllowedMethods[i] = strings.ToUpper(method)
+	}
+	if v, ok := m["allow_credentials"]; ok {
+		if p.AllowCredentials, err = cast.ToBoolE(v); err != nil {
+			return nil, fmt.Errorf("[allow_credentials] should be true or false, got: %v", v)
+		}
+	}
+	if v := strings.TrimSpace(cast.ToString(m["max_age"])); len(v) > 0 {
+		if p.MaxAge, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("[max_age] should be a duration like \"10m\", got: %s", v)
+		}
+	}
+	if len(p.AllowedOrigins) == 0 {
+		return nil, errors.New("[allowed_origins] is required")
+	}
+	return p, nil
+}
+
+// checkKeys returns an error if m has keys which are not in valid
+func checkKeys(m map[string]interface{}, valid []string) error {
+	unknown := make([]string, 0)
+	for k := range m {
+		if !contains(valid, k) {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown keys: %s, valid keys are: %s", strings.Join(unknown, ", "), strings.Join(valid, ", "))
+	}
+	return nil
+}
+
+// GlobalCORS returns the CORS policy set with "cors_xxx" in "config", nil if "cors_allowed_origins" is not set
+func (c *Config) GlobalCORS() *CORSPolicy {
+	m := make(map[string]interface{})
+	for _, field := range corsFields {
+		if v, ok := c.configs["cors_"+field]; ok {
+			m[field] = v
+		}
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	p, err := parseCORSPolicy(m)
+	if err != nil {
+		panic("invalid CORS setting in [config], " + strings.Replace(err.Error(), "[", "[cors_", 1))
+	}
+	return p
+}
+
+// loadCORS loads policies of url patterns in "cors"
+func (c *Config) loadCORS() {
+	raw := c.Get(corsKey)
+	if raw == nil {
+		return
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		panic(fmt.Sprintf("invalid [%s], should be a list, got: %v", corsKey, raw))
+	}
+	for i, item := range list {
+		m, err := cast.ToStringMapE(item)
+		path := strings.TrimSpace(cast.ToString(m["path"]))
+		if err == nil {
+			err = checkKeys(m, append([]string{"path"}, corsFields...))
+		}
+		if err == nil && !strings.HasPrefix(path, "/") {
+			err = errors.New("[path] should start with '/', got: " + path)
+		}
+		var p *CORSPolicy
+		if err == nil {
+			p, err = parseCORSPolicy(m)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("%s: invalid [%s] #%d, %s", c.position(corsKey, i, "path: "+path), corsKey, i+1, err))
+		}
+		c.cors[path] = p
+	}
+}
+
+// corsPolicy returns the CORS policy of url pattern path, nil if CORS is not enabled
+func (c *Config) corsPolicy(path string) *CORSPolicy {
+	if p, ok := c.cors[path]; ok {
+		return p
+	}
+	return c.GlobalCORS()
+}
+
+func (p *CORSPolicy) allowOrigin(origin string) bool {
+	return len(origin) > 0 && (contains(p.AllowedOrigins, "*") || contains(p.AllowedOrigins, origin))
+}
+
+// setOrigin sets "Access-Control-Allow-Origin", the origin is echoed unless any origin is allowed
+// without credentials, so that responses with credentials are valid.
+func (p *CORSPolicy) setOrigin(h http.Header, origin string) {
+	if contains(p.AllowedOrigins, "*") && !p.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	}
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// withCORS sets CORS headers to responses of requests from allowed origins
+func withCORS(h func(http.ResponseWriter, *http.Request), p *CORSPolicy) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if origin := req.Header.Get("Origin"); p.allowOrigin(origin) {
+			p.setOrigin(resp.Header(), origin)
+			if len(p.ExposedHeaders) > 0 {
+				resp.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+		}
+		h(resp, req)
+	}
+}
+
+// preflightHandler responds to preflight requests, methods are the methods mapped to the url pattern,
+// they are allowed if p.AllowedMethods is empty.
+func preflightHandler(p *CORSPolicy, methods []string) func(http.ResponseWriter, *http.Request) {
+	allowedMethods := p.AllowedMethods
+	if len(allowedMethods) == 0 {
+		allowedMethods = methods
+	}
+	return func(resp http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if !p.allowOrigin(origin) {
+			http.Error(resp, "turbo: CORS origin not allowed: "+origin, http.StatusForbidden)
+			return
+		}
+		method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+		if !contains(allowedMethods, method) {
+			http.Error(resp, "turbo: CORS method not allowed: "+method, http.StatusForbidden)
+			return
+		}
+		headers, _ := parseNames(req.Header.Get("Access-Control-Request-Headers"))
+		for _, header := range headers {
+			if !contains(p.AllowedHeaders, "*") && !containsFold(p.AllowedHeaders, header) {
+				http.Error(resp, "turbo: CORS header not allowed: "+header, http.StatusForbidden)
+				return
+			}
+		}
+		h := resp.Header()
+		p.setOrigin(h, origin)
+		h.Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
+		if len(headers) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		}
+		if p.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+		}
+		resp.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
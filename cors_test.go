@@ -0,0 +1,132 @@
+package turbo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setupRoutedServer builds a server with config, register registers components before they are loaded
+func setupRoutedServer(t *testing.T, config string, register ...func(s *Server)) *GrpcServer {
+	s := &GrpcServer{
+		Server:  &Server{Config: NewConfigFromSource("grpc", NewStaticSource("test", []byte(config))), Components: new(Components)},
+		gClient: &grpcClient{grpcService: &testServiceClient{}},
+	}
+	s.RegisterHijacker("hijacker", func(resp http.ResponseWriter, req *http.Request) { resp.Write([]byte("hijacked")) })
+	for _, r := range register {
+		r(s.Server)
+	}
+	r, err := router(s, s.Config)
+	assert.Nil(t, err)
+	s.swap(s.Config, s.loadComponents(s.Config), r)
+	return s
+}
+
+func routedRequest(s *GrpcServer, method, path string, header map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	return w
+}
+
+func TestCORS(t *testing.T) {
+	s := setupRoutedServer(t, `config:
+  http_port: 8081
+  cors_allowed_origins: https://a.com
+  cors_allowed_headers: Content-Type
+  cors_exposed_headers: X-Request-Id
+  cors_max_age: 10m
+urlmapping:
+  - GET /hello SayHello
+  - POST /hello SayHello
+  - GET /public SayHello
+hijacker:
+  - GET,POST /hello hijacker
+  - GET /public hijacker
+cors:
+  - path: /public
+    allowed_origins: ["*"]
+`)
+	w := routedRequest(s, "OPTIONS", "/hello", map[string]string{"Origin": "https://a.com",
+		"Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "content-type"})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://a.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+	w = routedRequest(s, "OPTIONS", "/hello", map[string]string{"Origin": "https://b.com", "Access-Control-Request-Method": "GET"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = routedRequest(s, "OPTIONS", "/hello", map[string]string{"Origin": "https://a.com", "Access-Control-Request-Method": "PUT"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = routedRequest(s, "OPTIONS", "/hello", map[string]string{"Origin": "https://a.com",
+		"Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Token"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = routedRequest(s, "GET", "/hello", map[string]string{"Origin": "https://a.com"})
+	assert.Equal(t, "hijacked", w.Body.String())
+	assert.Equal(t, "https://a.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-Id", w.Header().Get("Access-Control-Expose-Headers"))
+	w = routedRequest(s, "GET", "/hello", map[string]string{"Origin": "https://b.com"})
+	assert.Equal(t, "hijacked", w.Body.String())
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = routedRequest(s, "GET", "/public", map[string]string{"Origin": "https://b.com"})
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Expose-Headers"), "a policy of a pattern replaces the global one")
+}
+
+func TestCORSDisabled(t *testing.T) {
+	s := setupRoutedServer(t, `config:
+  http_port: 8081
+urlmapping:
+  - GET /hello SayHello
+hijacker:
+  - GET /hello hijacker
+`)
+	w := routedRequest(s, "OPTIONS", "/hello", map[string]string{"Origin": "https://a.com", "Access-Control-Request-Method": "GET"})
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w = routedRequest(s, "GET", "/hello", map[string]string{"Origin": "https://a.com"})
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSConfig(t *testing.T) {
+	file, remove := writeTestConfig(t, `config:
+  http_port: 8081
+urlmapping:
+  - GET /hello SayHello
+routes:
+  - method: GET,PUT
+    path: /user
+    rpc: GetUser
+    cors:
+      allowed_origins: https://a.com
+      allowed_methods: [get]
+      allow_credentials: true
+      max_age: 1h
+`)
+	defer remove()
+	c := NewConfig("grpc", file)
+	assert.Nil(t, c.corsPolicy("/hello"))
+	assert.Equal(t, &CORSPolicy{AllowedOrigins: []string{"https://a.com"}, AllowedMethods: []string{"GET"},
+		AllowCredentials: true, MaxAge: time.Hour},
+		c.corsPolicy("/user"))
+
+	invalid, remove := writeTestConfig(t, `urlmapping:
+  - GET /hello SayHello
+cors:
+  - path: /hello
+    allowed_headers: [X-Token]
+`)
+	defer remove()
+	defer func() {
+		assert.Equal(t, invalid+":4: invalid [cors] #1, [allowed_origins] is required", recover())
+	}()
+	NewConfig("grpc", invalid)
+}
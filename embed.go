@@ -0,0 +1,97 @@
+package turbo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// embeddedHandler connects the backend client, and returns the handler of s,
+// configs from ReloadConfig() are applied until s is shut down.
+func embeddedHandler(s Servable) http.Handler {
+	sf := s.ServerField()
+	if rs, ok := s.(rpcServer); ok {
+		rs.connectClient()
+	}
+	h := Handler(s)
+	sf.reloadOnce.Do(func() {
+		go reloadLoop(s)
+	})
+	return h
+}
+
+// errAlreadyStarted is returned by Start() if it's called on a server which has been started
+var errAlreadyStarted = errors.New("turbo: the server has been started, a server can be started only once")
+
+// start serves HTTP on "http_address" or "http_port" with the handler of s, and the admin server
+// if "admin_address" or "admin_port" is set, s is shut down when ctx is done.
+// s can be started only once, if it fails to start, what's started is stopped, and s can't be started again.
+func start(ctx context.Context, s Servable) (err error) {
+	sf := s.ServerField()
+	if !atomic.CompareAndSwapInt32(&sf.started, 0, 1) {
+		return errAlreadyStarted
+	}
+	var lis net.Listener
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("turbo: failed to start, error: %v", e)
+		}
+		if err != nil {
+			abortStart(s, lis)
+		}
+	}()
+	if err = sf.Initializer.InitService(s); err != nil {
+		return err
+	}
+	c := sf.currentConfig()
+	if lis, err = listen(c.HTTPAddress(), c.UnixSocketMode()); err != nil {
+		return err
+	}
+	embeddedHandler(s)
+	hs := sf.newHTTPServer(c, "")
+	sf.mu.Lock()
+	sf.httpServer = hs
+	sf.httpAddr = lis.Addr()
+	sf.mu.Unlock()
+	go func() {
+		if err := hs.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP Server failed to serve: %v", err)
+		}
+	}()
+	log.Info("HTTP Server started at ", lis.Addr())
+	sf.adminServer = startAdminServer(s)
+	sf.setReady(true)
+	go func() {
+		select {
+		case <-ctx.Done():
+			quit(s)
+		case <-sf.Context().Done():
+		}
+	}()
+	return nil
+}
+
+// abortStart stops what's started by a failed start(), the reload loop is stopped by cancelling the context of s
+func abortStart(s Servable, lis net.Listener) {
+	sf := s.ServerField()
+	if lis != nil {
+		lis.Close()
+	}
+	if rs, ok := s.(rpcServer); ok {
+		logErrorIf(rs.closeClient())
+	}
+	if sf.cancel != nil {
+		sf.cancel()
+	}
+}
+
+// Addr returns the address HTTP server listens on, e.g. to find the port of "http_port: 0",
+// it's nil if the HTTP server is not started.
+func (s *Server) Addr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.httpAddr
+}
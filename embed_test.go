@@ -0,0 +1,89 @@
+package turbo
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newEmbeddedServer(name string) *GrpcServer {
+	c := NewConfigFromSource("grpc", NewStaticSource(name, []byte(`config:
+  http_port: 0
+urlmapping:
+  - GET /hello SayHello
+`)))
+	return NewGrpcServerWithConfig(nil, c).WithClient(nil,
+		func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+			return name + " " + methodName, nil
+		})
+}
+
+func TestEmbeddedServers(t *testing.T) {
+	s1, s2 := newEmbeddedServer("s1"), newEmbeddedServer("s2")
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Nil(t, s1.Start(ctx))
+	assert.Nil(t, s2.Start(context.Background()))
+	assert.NotEqual(t, s1.Addr().String(), s2.Addr().String())
+
+	get := func(s *GrpcServer) (string, error) {
+		resp, err := http.Get("http://" + s.Addr().String() + "/hello")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+	body, err := get(s1)
+	assert.Nil(t, err)
+	assert.Equal(t, `"s1 SayHello"`, body)
+	body, err = get(s2)
+	assert.Nil(t, err)
+	assert.Equal(t, `"s2 SayHello"`, body)
+
+	cancel()
+	select {
+	case <-s1.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("s1 is not shut down when ctx is done")
+	}
+	assert.Nil(t, s2.Shutdown(context.Background()))
+	assert.Nil(t, s2.Shutdown(context.Background()))
+	_, err = get(s2)
+	assert.NotNil(t, err)
+}
+
+func TestEmbeddedHandler(t *testing.T) {
+	s := newEmbeddedServer("embedded")
+	defer s.Shutdown(context.Background())
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/hello", nil)
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, `"embedded SayHello"`, w.Body.String())
+	assert.Nil(t, s.Addr())
+
+	s.WithClient(nil, nil)
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestEmbeddedStartOnce(t *testing.T) {
+	s := newEmbeddedServer("once")
+	assert.Nil(t, s.Start(context.Background()))
+	defer s.Shutdown(context.Background())
+	assert.Equal(t, errAlreadyStarted, s.Start(context.Background()))
+
+	// the port is taken by s
+	failed := newEmbeddedServer("failed")
+	failed.Config.configs[httpAddress] = s.Addr().String()
+	assert.NotNil(t, failed.Start(context.Background()))
+	assert.NotNil(t, failed.Context().Err(), "the reload loop should be stopped")
+	assert.Nil(t, failed.Addr())
+	assert.False(t, failed.Ready())
+	assert.Equal(t, errAlreadyStarted, failed.Start(context.Background()))
+}
@@ -90,7 +90,9 @@ var GrpcSwitcher = func(s turbo.Servable, methodName string, resp http.ResponseW
 		if err != nil {
 			return nil, err
 		}
-		rpcResponse, err = s.Service().(g.{{$.ServiceName}}Client).{{$MethodName}}(req.Context(), request, callOptions...){{end}}
+		rpcResponse, err = turbo.Coalesce(req, methodName, request, func() (interface{}, error) {
+			return s.Service().(g.{{$.ServiceName}}Client).{{$MethodName}}(req.Context(), request, callOptions...)
+		}){{end}}
 	default:
 		return nil, errors.New("No such method[" + methodName + "]")
 	}
@@ -367,7 +369,9 @@ var ThriftSwitcher = func(s turbo.Servable, methodName string, resp http.Respons
 		if err != nil {
 			return nil, err
 		}{{end}}
-		return s.Service().(*gen.{{$.ServiceName}}Client).{{$MethodName}}({{index $.Parameters $i}})
+		return turbo.Coalesce(req, methodName, {{if index $.NotEmptyParameters $i}}params{{else}}nil{{end}}, func() (interface{}, error) {
+			return s.Service().(*gen.{{$.ServiceName}}Client).{{$MethodName}}({{index $.Parameters $i}})
+		})
 {{end}}
 	default:
 		return nil, errors.New("No such method[" + methodName + "]")
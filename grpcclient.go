@@ -1,12 +1,26 @@
 package turbo
 
 import (
+	"context"
+	"fmt"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"sync"
+	"time"
 )
 
+// reconnectTimeout is the max time to wait for a new connection when reconnecting on reloading config
+const reconnectTimeout = time.Second * 5
+
 type grpcClient struct {
-	grpcService interface{}
-	conn        *grpc.ClientConn
+	mu            sync.RWMutex
+	addr          string
+	clientCreator func(conn *grpc.ClientConn) interface{}
+	grpcService   interface{}
+	conn          *grpc.ClientConn
+	// dialer connects to the grpc service in process, addr is ignored if it's set
+	dialer func(addr string, timeout time.Duration) (net.Conn, error)
 }
 
 func (g *grpcClient) init(addr string, clientCreator func(conn *grpc.ClientConn) interface{}) {
@@ -16,19 +30,84 @@ func (g *grpcClient) init(addr string, clientCreator func(conn *grpc.ClientConn)
 		return
 	}
 	log.Info("[grpc]connecting addr:", addr)
+	g.addr = addr
+	g.clientCreator = clientCreator
 	g.dial(addr)
 	g.grpcService = clientCreator(g.conn)
 }
 
 func (g *grpcClient) dial(address string) {
 	var err error
-	g.conn, err = grpc.Dial(address, grpc.WithInsecure())
+	g.conn, err = grpc.Dial(address, g.dialOptions(address)...)
 	logPanicIf(err)
 }
 
+// dialOptions returns the options to dial addr, which is a unix domain socket address if it begins with "unix://"
+func (g *grpcClient) dialOptions(addr string) []grpc.DialOption {
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if g.dialer != nil {
+		opts = append(opts, grpc.WithDialer(g.dialer))
+	} else if isUnixAddr(addr) {
+		opts = append(opts, grpc.WithDialer(func(_ string, timeout time.Duration) (net.Conn, error) {
+			return dial(addr, timeout)
+		}))
+	}
+	return opts
+}
+
+func (g *grpcClient) service() interface{} {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.grpcService
+}
+
+func (g *grpcClient) connection() *grpc.ClientConn {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.conn
+}
+
+// reconnect connects to addr if it's changed, and returns a function to switch the client to the new connection,
+// the old connection is closed after closeDelay by it, to let in-flight RPCs on it complete.
+// The client is left as it is until the returned function is called, or if addr can't be connected in reconnectTimeout.
+func (g *grpcClient) reconnect(addr string, closeDelay time.Duration) (func(), error) {
+	g.mu.RLock()
+	current, currentAddr := g.conn, g.addr
+	g.mu.RUnlock()
+	if current == nil || addr == currentAddr {
+		return func() {}, nil
+	}
+	log.Info("[grpc]reconnecting addr:", addr)
+	ctx, cancel := context.WithTimeout(context.Background(), reconnectTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, append(g.dialOptions(addr), grpc.WithBlock())...)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		g.mu.Lock()
+		old := g.conn
+		g.addr, g.conn, g.grpcService = addr, conn, g.clientCreator(conn)
+		g.mu.Unlock()
+		time.AfterFunc(closeDelay, func() { old.Close() })
+	}, nil
+}
+
 func (g *grpcClient) close() error {
-	if g.conn == nil {
+	conn := g.connection()
+	if conn == nil {
 		return nil
 	}
-	return g.conn.Close()
+	return conn.Close()
+}
+
+func (g *grpcClient) checkHealth(ctx context.Context) error {
+	resp, err := healthpb.NewHealthClient(g.connection()).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("grpc service status: %s", resp.Status)
+	}
+	return nil
 }
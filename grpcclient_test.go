@@ -4,6 +4,7 @@ import (
 	logger "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"net"
 	"testing"
 )
 
@@ -35,3 +36,34 @@ func TestGrpcService(t *testing.T) {
 	s := &GrpcServer{gClient: new(grpcClient)}
 	s.Service()
 }
+
+func TestGrpcReconnect(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := grpc.NewServer()
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("127.0.0.1:50051", grpc.WithInsecure())
+	assert.Nil(t, err)
+	g := &grpcClient{
+		addr:          "127.0.0.1:50051",
+		conn:          conn,
+		clientCreator: func(conn *grpc.ClientConn) interface{} { return conn },
+	}
+	g.grpcService = g.clientCreator(conn)
+
+	switchClient, err := g.reconnect("127.0.0.1:50051", 0)
+	assert.Nil(t, err)
+	switchClient()
+	assert.Equal(t, conn, g.service())
+
+	switchClient, err = g.reconnect(lis.Addr().String(), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, conn, g.service())
+	switchClient()
+	assert.Equal(t, lis.Addr().String(), g.addr)
+	assert.True(t, conn != g.service(), "the client should be switched to a new connection")
+	assert.Equal(t, g.connection(), g.service())
+	g.close()
+}
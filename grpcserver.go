@@ -1,34 +1,57 @@
 package turbo
 
 import (
+	"context"
+	"errors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/test/bufconn"
 	"net"
 	"net/http"
+	"time"
+)
+
+const (
+	transportTCP       = "tcp"
+	transportInProcess = "inprocess"
+	// inProcessAddr is the address of the grpc service served in process, it's not dialed
+	inProcessAddr = "inprocess"
+	// inProcessBufferSize is the size of the in-memory connection buffer of the grpc service served in process
+	inProcessBufferSize = 1 << 20
 )
 
 type GrpcServer struct {
 	*Server
-	gClient    *grpcClient
-	httpServer *http.Server
-	grpcServer *grpc.Server
+	gClient      *grpcClient
+	grpcServer   *grpc.Server
+	grpcListener net.Listener
+	healthServer *health.Server
+	// inProcess is the in-memory listener of the grpc service if "grpc_transport" is "inprocess"
+	inProcess *bufconn.Listener
+	// clientCreator creates the client of backend service, it's set by WithClient()
+	clientCreator grpcClientCreator
 }
 
 func NewGrpcServer(initializer Initializable, configFilePath string) *GrpcServer {
+	return NewGrpcServerWithConfig(initializer, NewConfig("grpc", configFilePath))
+}
+
+// NewGrpcServerWithConfig returns a GrpcServer with a loaded config, e.g. a config from NewConfigFromSource()
+func NewGrpcServerWithConfig(initializer Initializable, c *Config) *GrpcServer {
 	if initializer == nil {
 		initializer = &defaultInitializer{}
 	}
 	s := &GrpcServer{
 		Server: &Server{
-			Config:       NewConfig("grpc", configFilePath),
-			Components:   new(Components),
-			reloadConfig: make(chan bool),
-			Initializer:  initializer,
+			Config:      c,
+			Components:  new(Components),
+			Initializer: initializer,
 		},
 		gClient: new(grpcClient),
 	}
 	s.initChans()
-	initLogger(s.Config)
 	return s
 }
 
@@ -36,66 +59,208 @@ type grpcClientCreator func(conn *grpc.ClientConn) interface{}
 
 // StartGRPC starts both HTTP server and GRPC service
 func (s *GrpcServer) StartGRPC(clientCreator grpcClientCreator, sw switcher, registerServer func(s *grpc.Server)) {
+	s.setupLogger()
 	log.Info("Starting Turbo...")
 	s.Initializer.InitService(s)
 	s.grpcServer = s.startGrpcServiceInternal(registerServer, false)
-	s.httpServer = s.startGrpcHTTPServerInternal(clientCreator, sw)
+	s.startGrpcHTTPServerInternal(clientCreator, sw)
 	s.watchConfig()
-	waitForQuit(s, s.httpServer, s.grpcServer, nil)
+	waitForQuit(s, true)
 	log.Info("Turbo exit, bye!")
 }
 
 // StartGrpcHTTPServer starts a HTTP server which sends requests via grpc
 func (s *GrpcServer) StartGrpcHTTPServer(clientCreator grpcClientCreator, sw switcher) {
+	s.setupLogger()
 	s.Initializer.InitService(s)
-	s.httpServer = s.startGrpcHTTPServerInternal(clientCreator, sw)
+	s.startGrpcHTTPServerInternal(clientCreator, sw)
 	s.watchConfig()
-	waitForQuit(s, s.httpServer, nil, nil)
+	waitForQuit(s, true)
 	log.Info("Grpc HttpServer exit, bye!")
 }
 
 // StartGrpcService starts a GRPC service
 func (s *GrpcServer) StartGrpcService(registerServer func(s *grpc.Server)) {
+	s.setupLogger()
 	s.Initializer.InitService(s)
 	s.grpcServer = s.startGrpcServiceInternal(registerServer, true)
-	waitForQuit(s, nil, s.grpcServer, nil)
+	waitForQuit(s, false)
 	log.Info("Grpc Service exit, bye!")
 }
 
-func (s *GrpcServer) startGrpcHTTPServerInternal(clientCreator grpcClientCreator, sw switcher) *http.Server {
+func (s *GrpcServer) startGrpcHTTPServerInternal(clientCreator grpcClientCreator, sw switcher) {
 	log.Info("Starting HTTP Server...")
-	switcherFunc = sw
-	s.gClient.init(s.Config.GrpcServiceHost()+":"+s.Config.GrpcServicePort(), clientCreator)
-	return startHTTPServer(s)
+	s.WithClient(clientCreator, sw)
+	s.connectClient()
+	startHTTPServer(s)
+}
+
+// WithClient sets the creator of the grpc client, and the switcher which calls its methods,
+// they are used by Start() and Handler().
+func (s *GrpcServer) WithClient(clientCreator grpcClientCreator, sw switcher) *GrpcServer {
+	s.clientCreator = clientCreator
+	s.switcher = sw
+	return s
+}
+
+// Start starts the HTTP server and returns, it's shut down when ctx is done, or Shutdown() is called.
+// Unlike StartGrpcHTTPServer(), it doesn't block, handle signals, watch the config file, or set up
+// the process-wide logger, so that turbo can be embedded in a program, e.g. with other servers.
+func (s *GrpcServer) Start(ctx context.Context) error {
+	return start(ctx, s)
+}
+
+// Handler returns an http.Handler which serves the routes of s, without starting an HTTP server,
+// see Start() for what's not done by it.
+func (s *GrpcServer) Handler() http.Handler {
+	return embeddedHandler(s)
+}
+
+// Shutdown stops s gracefully, it returns an error if requests in flight don't complete before ctx is done
+func (s *GrpcServer) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, s)
 }
 
+func (s *GrpcServer) connectClient() {
+	if s.clientCreator == nil {
+		return
+	}
+	c := s.currentConfig()
+	if c.GrpcTransport() == transportInProcess {
+		if s.inProcess == nil {
+			log.Panic("turbo: [grpc_transport] is inprocess, but the grpc service is not served in this process, start it with StartGRPC()")
+		}
+		s.gClient.dialer = func(string, time.Duration) (net.Conn, error) { return s.inProcess.Dial() }
+		s.gClient.init(inProcessAddr, s.clientCreator)
+		return
+	}
+	s.gClient.init(c.GrpcServiceAddress(), s.clientCreator)
+}
+
+// startGrpcServiceInternal serves the grpc service on "grpc_service_address" or "grpc_service_port", or in process if "grpc_transport" is "inprocess",
+// a service started alone is always served on the port, since the HTTP server runs in another process.
 func (s *GrpcServer) startGrpcServiceInternal(registerServer func(s *grpc.Server), alone bool) *grpc.Server {
 	log.Info("Starting GRPC Service...")
-	lis, err := net.Listen("tcp", ":"+s.Config.GrpcServicePort())
-	logPanicIf(err)
+	c := s.currentConfig()
+	var lis net.Listener
+	if !alone && c.GrpcTransport() == transportInProcess {
+		s.inProcess = bufconn.Listen(inProcessBufferSize)
+		lis = s.inProcess
+	} else {
+		var err error
+		lis, err = listen(c.grpcServiceListenAddress(), c.UnixSocketMode())
+		logPanicIf(err)
+	}
 	grpcServer := grpc.NewServer()
 	registerServer(grpcServer)
 	reflection.Register(grpcServer)
-	go func() {
-		if err := grpcServer.Serve(lis); err != nil {
-			log.Printf("GRPC Service failed to serve: %v", err)
-		}
-	}()
+	s.healthServer = health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, s.healthServer)
+	s.healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	s.healthServer.SetServingStatus(c.GrpcServiceName(), healthpb.HealthCheckResponse_SERVING)
+	s.grpcListener = lis
+	go s.serveGrpc(grpcServer, lis)
 	log.Info("GRPC Service started")
 	return grpcServer
 }
 
+func (s *GrpcServer) serveGrpc(grpcServer *grpc.Server, lis net.Listener) {
+	if err := grpcServer.Serve(lis); err != nil {
+		s.mu.RLock()
+		current := s.grpcListener == lis
+		s.mu.RUnlock()
+		// the listener is closed on purpose if it's replaced on reloading config
+		if current {
+			log.Printf("GRPC Service failed to serve: %v", err)
+		}
+	}
+}
+
 // GrpcService returns a grpc client instance,
 // example: client := turbo.GrpcService().(proto.YourServiceClient)
 func (s *GrpcServer) Service() interface{} {
-	if s == nil || s.gClient == nil || s.gClient.grpcService == nil {
+	if s == nil || s.gClient == nil || s.gClient.service() == nil {
 		log.Panic("grpc connection not initiated!")
 	}
-	return s.gClient.grpcService
+	return s.gClient.service()
 }
 func (s *GrpcServer) ServerField() *Server { return s.Server }
 
+func (s *GrpcServer) client() interface{} {
+	if s.gClient == nil {
+		return nil
+	}
+	return s.gClient.service()
+}
+
+func (s *GrpcServer) startDraining() {
+	if s.healthServer != nil {
+		s.healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		s.healthServer.SetServingStatus(s.currentConfig().GrpcServiceName(), healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+func (s *GrpcServer) closeClient() error {
+	return s.gClient.close()
+}
+
+// stopService stops the grpc service gracefully
+func (s *GrpcServer) stopService(ctx context.Context) {
+	if s.grpcServer == nil {
+		return
+	}
+	stopGrpcServer(ctx, s.grpcServer)
+	log.Info("Grpc Server stopped")
+}
+
+// reloadEndpoints moves the grpc service to the new address if it's served in this process,
+// and reconnects the grpc client if the address of grpc service is changed.
+// The new listener and client connection are both opened before any of them is switched to.
+func (s *GrpcServer) reloadEndpoints(current, c *Config) error {
+	if c.GrpcTransport() != current.GrpcTransport() {
+		return errors.New("turbo: [grpc_transport] can not be changed on reloading config, restart the server to change it")
+	}
+	if c.GrpcTransport() == transportInProcess && s.inProcess != nil {
+		// the grpc service and the client don't use any address
+		return nil
+	}
+	var lis net.Listener
+	if s.grpcServer != nil && c.grpcServiceListenAddress() != current.grpcServiceListenAddress() {
+		var err error
+		if lis, err = listen(c.grpcServiceListenAddress(), c.UnixSocketMode()); err != nil {
+			return err
+		}
+	}
+	switchClient, err := s.gClient.reconnect(c.GrpcServiceAddress(), c.ShutdownTimeout())
+	if err != nil {
+		if lis != nil {
+			lis.Close()
+		}
+		return err
+	}
+	if lis != nil {
+		s.mu.Lock()
+		old := s.grpcListener
+		s.grpcListener = lis
+		s.mu.Unlock()
+		go s.serveGrpc(s.grpcServer, lis)
+		// established connections are kept by grpcServer, only new connections go to the new port
+		old.Close()
+		log.Info("GRPC Service moved to ", lis.Addr())
+	}
+	switchClient()
+	return nil
+}
+
+// checkBackend checks the grpc service with the standard grpc health checking protocol
+func (s *GrpcServer) checkBackend(ctx context.Context) error {
+	if s.gClient == nil || s.gClient.connection() == nil {
+		return errors.New("grpc client is not connected")
+	}
+	return s.gClient.checkHealth(ctx)
+}
+
 func (s *GrpcServer) Stop() {
 	log.Info("Stop() invoked, Service is stopping...")
-	quit(s, s.httpServer, s.grpcServer, nil)
+	quit(s)
 }
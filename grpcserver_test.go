@@ -0,0 +1,48 @@
+package turbo
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"testing"
+)
+
+func TestInProcessTransport(t *testing.T) {
+	c := NewConfigFromMap("grpc", map[string]string{httpPort: "0", grpcServiceName: "TestService",
+		grpcServiceHost: "127.0.0.1", grpcServicePort: "50099", grpcTransport: "inprocess"})
+	s := NewGrpcServerWithConfig(nil, c)
+	s.grpcServer = s.startGrpcServiceInternal(func(*grpc.Server) {}, false)
+	defer s.stopService(context.Background())
+	s.WithClient(func(conn *grpc.ClientConn) interface{} { return healthpb.NewHealthClient(conn) }, nil)
+	s.connectClient()
+	defer s.closeClient()
+
+	resp, err := s.Service().(healthpb.HealthClient).Check(context.Background(),
+		&healthpb.HealthCheckRequest{Service: "TestService"})
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	// grpc_service_port is not listened on
+	lis, err := net.Listen("tcp", ":50099")
+	assert.Nil(t, err)
+	lis.Close()
+
+	changed := NewConfigFromMap("grpc", map[string]string{httpPort: "0", grpcServicePort: "50099"})
+	assert.Equal(t, errors.New("turbo: [grpc_transport] can not be changed on reloading config, restart the server to change it"),
+		s.reloadEndpoints(c, changed))
+	assert.Nil(t, s.reloadEndpoints(c, c))
+}
+
+func TestInProcessTransportWithoutService(t *testing.T) {
+	s := NewGrpcServerWithConfig(nil, NewConfigFromMap("grpc", map[string]string{httpPort: "0", grpcTransport: "inprocess"}))
+	s.WithClient(func(conn *grpc.ClientConn) interface{} { return healthpb.NewHealthClient(conn) }, nil)
+	assert.Panics(t, s.connectClient)
+
+	defer func() {
+		assert.Equal(t, `[grpc_transport] should be "tcp" or "inprocess", got: udp`, recover())
+	}()
+	NewConfigFromMap("grpc", map[string]string{httpPort: "0", grpcTransport: "udp"})
+}
@@ -0,0 +1,253 @@
+package turbo
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// jwtKeySet holds keys to verify JWT signatures, a key is a []byte for HMAC,
+// a *rsa.PublicKey for RSA, or a *ecdsa.PublicKey for ECDSA.
+type jwtKeySet struct {
+	// byID holds keys with a "kid" in JWKS
+	byID map[string]interface{}
+	// keys holds all keys, they are tried in order if the token has no "kid", or the "kid" is unknown
+	keys []interface{}
+}
+
+func (s *jwtKeySet) add(kid string, key interface{}) {
+	if s.byID == nil {
+		s.byID = make(map[string]interface{})
+	}
+	if len(kid) > 0 {
+		s.byID[kid] = key
+	}
+	s.keys = append(s.keys, key)
+}
+
+var jwtHashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+// verifyJWT verifies the signature and the time claims of token, and returns its claims
+func verifyJWT(token string, keys *jwtKeySet, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header, %s", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	candidates := keys.keys
+	if key, ok := keys.byID[header.Kid]; ok {
+		candidates = []interface{}{key}
+	}
+	verified := false
+	for _, key := range candidates {
+		if err = verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		if err == nil {
+			err = errors.New("no key to verify the token")
+		}
+		return nil, err
+	}
+	claims := make(map[string]interface{})
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims, %s", err)
+	}
+	if exp, ok := numericClaim(claims, "exp"); ok && !now.Before(time.Unix(exp, 0)) {
+		return nil, errors.New("token is expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Before(time.Unix(nbf, 0)) {
+		return nil, errors.New("token is not valid yet")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func numericClaim(claims map[string]interface{}, name string) (int64, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// verifySignature verifies sig of input with key, the type of key must match alg,
+// so that a public key can never be used as an HMAC secret.
+func verifySignature(alg string, key interface{}, input string, sig []byte) error {
+	if len(alg) != 5 {
+		return errors.New("unsupported alg: " + alg)
+	}
+	hash, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return errors.New("unsupported alg: " + alg)
+	}
+	switch k := key.(type) {
+	case []byte:
+		if alg[:2] != "HS" {
+			return errors.New("alg " + alg + " doesn't match an HMAC key")
+		}
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(input))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			return errors.New("alg " + alg + " doesn't match an RSA key")
+		}
+		h := hash.New()
+		h.Write([]byte(input))
+		if rsa.VerifyPKCS1v15(k, hash, h.Sum(nil), sig) != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != size*2 {
+			return errors.New("alg " + alg + " doesn't match an ECDSA key")
+		}
+		h := hash.New()
+		h.Write([]byte(input))
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, h.Sum(nil), r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T", key)
+}
+
+// loadJWKS loads keys in a JWKS file, keys with "use" other than "sig" are ignored
+func loadJWKS(file string, keys *jwtKeySet) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &jwks); err != nil {
+		return fmt.Errorf("invalid JWKS file %s, %s", file, err)
+	}
+	for i, k := range jwks.Keys {
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		switch k.Kty {
+		case "RSA":
+			n, e := decodeBigInt(k.N), decodeBigInt(k.E)
+			if n != nil && e != nil {
+				key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+			}
+		case "EC":
+			curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+			x, y := decodeBigInt(k.X), decodeBigInt(k.Y)
+			if curve, ok := curves[k.Crv]; ok && x != nil && y != nil {
+				key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+			}
+		case "oct":
+			if b, err := base64.RawURLEncoding.DecodeString(k.K); err == nil && len(b) > 0 {
+				key = b
+			}
+		}
+		if key == nil {
+			return fmt.Errorf("invalid JWKS file %s, key #%d is invalid or not supported", file, i+1)
+		}
+		keys.add(k.Kid, key)
+	}
+	return nil
+}
+
+func decodeBigInt(s string) *big.Int {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(b)
+}
+
+// loadPublicKey loads a RSA or ECDSA public key, or a certificate, in a PEM file
+func loadPublicKey(file string) (interface{}, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM data found in " + file)
+	}
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %s in %s", block.Type, file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key in %s, %s", file, err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T in %s", key, file)
+}
@@ -0,0 +1,184 @@
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// errBodyTooLarge is returned when reading a request body beyond its limit
+var errBodyTooLarge = &HTTPError{Status: http.StatusRequestEntityTooLarge, Message: "turbo: request body too large"}
+
+// limitedBody fails with errBodyTooLarge once more than remaining bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	// read one more byte than remaining, to tell a body of exactly the limit from a larger one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), errBodyTooLarge
+	}
+	return n, err
+}
+
+// withBodyLimit responds 413 to requests with a body larger than limit,
+// a body without Content-Length fails with errBodyTooLarge when it's read beyond limit.
+func withBodyLimit(h func(http.ResponseWriter, *http.Request), limit int64) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if req.ContentLength > limit {
+			http.Error(resp, errBodyTooLarge.Message, errBodyTooLarge.Status)
+			return
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = &limitedBody{ReadCloser: req.Body, remaining: limit}
+		}
+		h(resp, req)
+	}
+}
+
+// acquire counts req in the requests being served, it responds 503 and returns false
+// when "max_concurrent_requests" is exceeded, the count must be released if it returns true.
+func (s *Server) acquire(resp http.ResponseWriter) bool {
+	n := atomic.AddInt64(&s.inflight, 1)
+	if max := s.currentConfig().MaxConcurrentRequests(); max > 0 && n > max {
+		atomic.AddInt64(&s.inflight, -1)
+		resp.Header().Set("Retry-After", "1")
+		http.Error(resp, "turbo: too many concurrent requests", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+func (s *Server) release() {
+	atomic.AddInt64(&s.inflight, -1)
+}
+
+// newHTTPServer returns an http.Server with timeouts and limits in c
+func (s *Server) newHTTPServer(c *Config, addr string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           http.HandlerFunc(s.serveHTTP),
+		ReadTimeout:       c.HTTPReadTimeout(),
+		ReadHeaderTimeout: c.HTTPReadHeaderTimeout(),
+		WriteTimeout:      c.HTTPWriteTimeout(),
+		IdleTimeout:       c.HTTPIdleTimeout(),
+		MaxHeaderBytes:    int(c.HTTPMaxHeaderBytes()),
+	}
+}
+
+// HTTPReadTimeout returns "http_read_timeout" in config file, the max time to read a request,
+// including the body, 0 means no timeout.
+func (c *Config) HTTPReadTimeout() time.Duration {
+	return c.durationValue(httpReadTimeout, 0)
+}
+
+// HTTPReadHeaderTimeout returns "http_read_header_timeout" in config file, defaults to "10s",
+// it's the max time to read request headers, which protects the server from slow clients.
+func (c *Config) HTTPReadHeaderTimeout() time.Duration {
+	return c.durationValue(httpReadHeaderTimeout, 10*time.Second)
+}
+
+// HTTPWriteTimeout returns "http_write_timeout" in config file, the max time to write a response,
+// 0 means no timeout.
+func (c *Config) HTTPWriteTimeout() time.Duration {
+	return c.durationValue(httpWriteTimeout, 0)
+}
+
+// HTTPIdleTimeout returns "http_idle_timeout" in config file, defaults to "2m",
+// it's the max time to wait for the next request on a keep-alive connection.
+func (c *Config) HTTPIdleTimeout() time.Duration {
+	return c.durationValue(httpIdleTimeout, 2*time.Minute)
+}
+
+// HTTPMaxHeaderBytes returns "http_max_header_bytes" in config file, e.g. "64KB",
+// 0 means the default of net/http, which is 1MB.
+func (c *Config) HTTPMaxHeaderBytes() int64 {
+	return c.sizeValue(httpMaxHeaderBytes)
+}
+
+// MaxBodySize returns "max_body_size" in config file, e.g. "4MB", the max size of request bodies
+// of routes without their own limit, 0 means no limit.
+func (c *Config) MaxBodySize() int64 {
+	return c.sizeValue(maxBodySize)
+}
+
+// MaxConcurrentRequests returns "max_concurrent_requests" in config file, requests beyond it
+// are responded with 503, 0 means no limit.
+func (c *Config) MaxConcurrentRequests() int64 {
+	return c.intValue(maxConcurrentRequests)
+}
+
+// loadBodyLimits loads lines like "POST /upload 10MB" in "body_limit"
+func (c *Config) loadBodyLimits() {
+	for i, line := range c.GetStringSlice("body_limit") {
+		values := strings.Fields(line)
+		var size int64
+		var err error
+		if len(values) == 3 {
+			size, err = parseSize(values[2])
+		}
+		if len(values) != 3 || err != nil {
+			panic(fmt.Sprintf("%s: invalid [body_limit] %q, should be \"METHODS PATH SIZE\", e.g. \"POST /upload 10MB\"",
+				c.position("body_limit", i, line), line))
+		}
+		c.bodyLimits[values[0]+" "+values[1]] = size
+	}
+}
+
+// routeBodyLimit returns the max size of request bodies of the route with methods and path,
+// it defaults to "max_body_size", 0 means no limit.
+func (c *Config) routeBodyLimit(methods, path string) int64 {
+	if size, ok := c.bodyLimits[methods+" "+path]; ok {
+		return size
+	}
+	return c.MaxBodySize()
+}
+
+func (c *Config) sizeValue(key string) int64 {
+	v := strings.TrimSpace(c.configs[key])
+	if len(v) == 0 {
+		return 0
+	}
+	size, err := parseSize(v)
+	if err != nil {
+		panic("[" + key + "] should be a size like \"4MB\", got: " + v)
+	}
+	return size
+}
+
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"B", 1}}
+
+// parseSize parses a size in bytes, or with a unit in B, KB, MB and GB, e.g. "512KB"
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid size: " + s)
+	}
+	return n * unit, nil
+}
@@ -0,0 +1,92 @@
+package turbo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyLimit(t *testing.T) {
+	s := setupRoutedServer(t, `config:
+  http_port: 8081
+  max_body_size: 16
+urlmapping:
+  - POST /hello SayHello
+  - POST /upload SayHello
+hijacker:
+  - POST /hello hijacker
+  - POST /upload hijacker
+body_limit:
+  - POST /upload 1KB
+`)
+	post := func(path, body string, chunked bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, "hijacked", post("/hello", "name=0123456789", false).Body.String())
+	w := post("/hello", "name=0123456789ab", false)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "turbo: request body too large\n", w.Body.String())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("/hello", "name=0123456789ab", true).Code)
+	assert.Equal(t, "hijacked", post("/upload", "name="+strings.Repeat("a", 1000), true).Body.String())
+
+	h := withBodyLimit(func(resp http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, errBodyTooLarge, BuildRequest(nil, &testMessage{}, req))
+	}, 8)
+	req, _ := http.NewRequest("POST", "/hello", strings.NewReader(`{"message":"hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.ContentLength = -1
+	h(httptest.NewRecorder(), req)
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	s := setupRoutedServer(t, `config:
+  http_port: 8081
+  max_concurrent_requests: 1
+urlmapping:
+  - GET /hello SayHello
+hijacker:
+  - GET /hello hijacker
+`)
+	w := routedRequest(s, "GET", "/hello", nil)
+	assert.Equal(t, "hijacked", w.Body.String())
+	assert.Equal(t, int64(0), s.inflight)
+
+	s.inflight = 1
+	w = routedRequest(s, "GET", "/hello", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, int64(1), s.inflight)
+}
+
+func TestHTTPServerLimits(t *testing.T) {
+	c := NewConfigFromMap("grpc", map[string]string{httpPort: "8081", httpReadTimeout: "5s", httpWriteTimeout: "10s",
+		httpMaxHeaderBytes: "64KB"})
+	hs := new(Server).newHTTPServer(c, ":8081")
+	assert.Equal(t, 5*time.Second, hs.ReadTimeout)
+	assert.Equal(t, 10*time.Second, hs.ReadHeaderTimeout)
+	assert.Equal(t, 10*time.Second, hs.WriteTimeout)
+	assert.Equal(t, 2*time.Minute, hs.IdleTimeout)
+	assert.Equal(t, 64<<10, hs.MaxHeaderBytes)
+
+	for s, size := range map[string]int64{"512": 512, "2kb": 2048, "1 MB": 1 << 20, "3GB": 3 << 30, "8B": 8} {
+		n, err := parseSize(s)
+		assert.Nil(t, err)
+		assert.Equal(t, size, n)
+	}
+	_, err := parseSize("1TB")
+	assert.NotNil(t, err)
+	defer func() {
+		assert.Equal(t, `[max_body_size] should be a size like "4MB", got: lots`, recover())
+	}()
+	NewConfigFromMap("grpc", map[string]string{httpPort: "8081", maxBodySize: "lots"})
+}
@@ -0,0 +1,137 @@
+package turbo
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unixScheme is the prefix of unix domain socket addresses, e.g. "unix:///var/run/turbo.sock"
+const unixScheme = "unix://"
+
+// splitAddr returns the network and the address of addr, which is like "unix:///var/run/turbo.sock", or "host:port"
+func splitAddr(addr string) (network, address string) {
+	if strings.HasPrefix(addr, unixScheme) {
+		return "unix", strings.TrimPrefix(addr, unixScheme)
+	}
+	return "tcp", addr
+}
+
+func isUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, unixScheme)
+}
+
+// listen listens on addr, the socket file of a unix domain socket is set to mode if it's not 0,
+// and a socket file left by a process exited is removed first.
+// A listener on addr inherited by socket activation or a graceful restart is used if there's one.
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	network, address := splitAddr(addr)
+	if lis := takeInherited(network, address); lis != nil {
+		return track(lis), nil
+	}
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil {
+			return nil, err
+		}
+	}
+	lis, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" && mode != 0 {
+		if err = os.Chmod(address, mode); err != nil {
+			lis.Close()
+			return nil, err
+		}
+	}
+	return track(lis), nil
+}
+
+// removeStaleSocket removes the socket file at path if no process is listening on it
+func removeStaleSocket(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("turbo: %s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("turbo: %s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// dial connects to addr, which is like "unix:///var/run/turbo.sock", or "host:port"
+func dial(addr string, timeout time.Duration) (net.Conn, error) {
+	network, address := splitAddr(addr)
+	return net.DialTimeout(network, address, timeout)
+}
+
+// HTTPAddress returns "http_address" in config file, e.g. "unix:///var/run/turbo.sock", or "127.0.0.1:8081",
+// the HTTP server listens on it, it defaults to ":[http_port]".
+func (c *Config) HTTPAddress() string {
+	if addr := strings.TrimSpace(c.configs[httpAddress]); len(addr) > 0 {
+		return addr
+	}
+	return ":" + strconv.FormatInt(c.HTTPPort(), 10)
+}
+
+// GrpcServiceAddress returns "grpc_service_address" in config file, e.g. "unix:///var/run/service.sock",
+// the grpc service listens on it, and the HTTP server connects to it,
+// it defaults to "[grpc_service_host]:[grpc_service_port]".
+func (c *Config) GrpcServiceAddress() string {
+	if addr := strings.TrimSpace(c.configs[grpcServiceAddress]); len(addr) > 0 {
+		return addr
+	}
+	return c.GrpcServiceHost() + ":" + c.GrpcServicePort()
+}
+
+// grpcServiceListenAddress returns the address the grpc service listens on,
+// a service without "grpc_service_address" listens on "grpc_service_port" of all interfaces.
+func (c *Config) grpcServiceListenAddress() string {
+	if addr := strings.TrimSpace(c.configs[grpcServiceAddress]); len(addr) > 0 {
+		return addr
+	}
+	return ":" + c.GrpcServicePort()
+}
+
+// ThriftServiceAddress returns "thrift_service_address" in config file, e.g. "unix:///var/run/service.sock",
+// the thrift service listens on it, and the HTTP server connects to it,
+// it defaults to "[thrift_service_host]:[thrift_service_port]".
+func (c *Config) ThriftServiceAddress() string {
+	if addr := strings.TrimSpace(c.configs[thriftServiceAddress]); len(addr) > 0 {
+		return addr
+	}
+	return c.ThriftServiceHost() + ":" + c.ThriftServicePort()
+}
+
+// thriftServiceListenAddress returns the address the thrift service listens on,
+// a service without "thrift_service_address" listens on "thrift_service_port" of all interfaces.
+func (c *Config) thriftServiceListenAddress() string {
+	if addr := strings.TrimSpace(c.configs[thriftServiceAddress]); len(addr) > 0 {
+		return addr
+	}
+	return ":" + c.ThriftServicePort()
+}
+
+// UnixSocketMode returns "unix_socket_mode" in config file, e.g. "0660", the permission of socket files
+// created for unix domain socket addresses, 0 means the default, which depends on umask.
+func (c *Config) UnixSocketMode() os.FileMode {
+	v := strings.TrimSpace(c.configs[unixSocketMode])
+	if len(v) == 0 {
+		return 0
+	}
+	mode, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || mode > 0777 {
+		panic("[unix_socket_mode] should be an octal permission like \"0660\", got: " + v)
+	}
+	return os.FileMode(mode)
+}
@@ -0,0 +1,107 @@
+package turbo
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "turbo")
+	defer os.RemoveAll(dir)
+	path := dir + "/turbo.sock"
+	network, address := splitAddr("unix://" + path)
+	assert.Equal(t, "unix", network)
+	assert.Equal(t, path, address)
+	network, address = splitAddr("127.0.0.1:8081")
+	assert.Equal(t, "tcp", network)
+	assert.Equal(t, "127.0.0.1:8081", address)
+
+	// a socket file left by a process exited
+	stale, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	lis, err := listen("unix://"+path, 0600)
+	assert.Nil(t, err)
+	fi, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	_, err = listen("unix://"+path, 0)
+	assert.Equal(t, "turbo: "+path+" is in use", err.Error())
+	lis.Close()
+
+	ioutil.WriteFile(dir+"/file", nil, 0644)
+	_, err = listen("unix://"+dir+"/file", 0)
+	assert.Equal(t, "turbo: "+dir+"/file exists and is not a socket", err.Error())
+
+	defer func() {
+		assert.Equal(t, `[unix_socket_mode] should be an octal permission like "0660", got: 0999`, recover())
+	}()
+	NewConfigFromMap("grpc", map[string]string{httpPort: "8081", unixSocketMode: "0999"})
+}
+
+func TestUnixSocketServers(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "turbo")
+	defer os.RemoveAll(dir)
+	c := NewConfigFromSource("grpc", NewStaticSource("unix", []byte(`config:
+  http_address: unix://`+dir+`/http.sock
+  grpc_service_name: TestService
+  grpc_service_address: unix://`+dir+`/grpc.sock
+  unix_socket_mode: "0660"
+urlmapping:
+  - GET /hello Check
+`)))
+	s := NewGrpcServerWithConfig(nil, c)
+	s.grpcServer = s.startGrpcServiceInternal(func(*grpc.Server) {}, false)
+	defer s.stopService(context.Background())
+	s.WithClient(func(conn *grpc.ClientConn) interface{} { return healthpb.NewHealthClient(conn) },
+		func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+			r, err := s.Service().(healthpb.HealthClient).Check(req.Context(), &healthpb.HealthCheckRequest{Service: "TestService"})
+			if err != nil {
+				return nil, err
+			}
+			return r.Status.String(), nil
+		})
+	assert.Nil(t, s.Start(context.Background()))
+	defer s.Shutdown(context.Background())
+	assert.Equal(t, "unix", s.Addr().Network())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", dir+"/http.sock")
+		},
+	}}
+	resp, err := client.Get("http://turbo/hello")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, `"SERVING"`, string(body))
+	fi, err := os.Stat(dir + "/grpc.sock")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0660), fi.Mode().Perm())
+}
+
+func TestThriftReadyzOverUnixSocket(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "turbo")
+	defer os.RemoveAll(dir)
+	addr := "unix://" + dir + "/thrift.sock"
+	lis, err := listen(addr, 0)
+	assert.Nil(t, err)
+	s := &ThriftServer{Server: &Server{Config: NewConfigFromMap("thrift", map[string]string{httpPort: "0"})},
+		tClient: &thriftClient{addr: addr, thriftService: struct{}{}}}
+	s.setReady(true)
+	w := adminGet(t, s, "/readyz")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	lis.Close()
+	w = adminGet(t, s, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
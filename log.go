@@ -3,13 +3,18 @@ package turbo
 import (
 	logger "github.com/sirupsen/logrus"
 	"io"
+	"log/syslog"
 	"os"
 	"path"
 	"runtime"
 	"strings"
+	"time"
 )
 
-var log *logger.Logger
+// log is the standard logger of logrus, it's shared by all servers in the process, not per server.
+// It's set up with config by a server started with StartXxx(), the last one if there are more than one,
+// and left as it is by servers started with Start() or Handler(), so that the program they are embedded in owns it.
+var log = logger.StandardLogger()
 
 // ContextHook is a hook to be fired when logging on the logging levels returned from
 // `Levels()` on your implementation of the interface. Note that this is not
@@ -49,7 +54,13 @@ func (hook ContextHook) Fire(entry *logger.Entry) error {
 	return nil
 }
 
-func setupLoggerFile(c *Config) {
+// logClosers holds the outputs opened by initLogger, they are closed after the logger is switched to new outputs.
+var logClosers []io.Closer
+
+// contextHookAdded is set once ContextHook is added to the logger, so that it's not added again by initLogger
+var contextHookAdded bool
+
+func setupLoggerFile(c *Config) (io.Writer, io.Closer) {
 	logPath := c.configs[turboLogPath]
 	if len(strings.TrimSpace(logPath)) == 0 {
 		logPath = "log"
@@ -60,27 +71,125 @@ func setupLoggerFile(c *Config) {
 	logPath = path.Clean(logPath)
 	err := os.MkdirAll(logPath, 0755)
 	panicIf(err)
-	file, err := os.OpenFile(logPath+"/turbo.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	w, err := newRotateWriter(logPath+"/turbo.log",
+		c.LogRotateMaxSize()*1024*1024,
+		rotateInterval(c.LogRotateInterval()),
+		int(c.LogRotateMaxBackups()),
+		time.Duration(c.LogRotateMaxAge())*time.Hour*24)
 	panicIf(err)
-	logger.SetOutput(file)
+	return w, w
+}
+
+func setupLoggerSyslog(c *Config) (io.Writer, io.Closer) {
+	w, err := syslog.Dial(c.LogSyslogNetwork(), c.LogSyslogAddress(), syslog.LOG_INFO|syslog.LOG_LOCAL0, c.LogSyslogTag())
+	panicIf(err)
+	return w, w
+}
+
+// loggerOutput opens the outputs in "log_output", and returns them with the closers of files and syslog opened,
+// if an output fails to open, those opened already are closed before panicking.
+func loggerOutput(c *Config) (out io.Writer, closers []io.Closer) {
+	defer func() {
+		if err := recover(); err != nil {
+			closeAll(closers)
+			panic(err)
+		}
+	}()
+	writers := make([]io.Writer, 0)
+	for _, o := range c.LogOutputs() {
+		switch o {
+		case "stdout":
+			writers = append(writers, os.Stdout)
+		case "stderr":
+			writers = append(writers, os.Stderr)
+		case "file":
+			w, closer := setupLoggerFile(c)
+			writers = append(writers, w)
+			closers = append(closers, closer)
+		case "syslog":
+			w, closer := setupLoggerSyslog(c)
+			writers = append(writers, w)
+			closers = append(closers, closer)
+		default:
+			panic("invalid [log_output]: " + o + ", should be stdout, stderr, file or syslog")
+		}
+	}
+	if len(writers) == 1 {
+		return writers[0], closers
+	}
+	return io.MultiWriter(writers...), closers
+}
+
+func loggerFormatter(format string) logger.Formatter {
+	switch format {
+	case "json":
+		return &logger.JSONFormatter{}
+	case "text":
+		return &logger.TextFormatter{}
+	default:
+		panic("invalid [log_format]: " + format + ", should be json or text")
+	}
 }
 
+func loggerLevel(level string) logger.Level {
+	l, err := logger.ParseLevel(level)
+	if err != nil {
+		panic("invalid [log_level]: " + level)
+	}
+	return l
+}
+
+func closeAll(closers []io.Closer) {
+	for _, closer := range closers {
+		closer.Close()
+	}
+}
+
+// initLogger sets up the logger with c, new outputs are opened before the old ones are closed,
+// so the logger is left as it was if c is invalid.
 func initLogger(c *Config) {
-	if c.Env() == "production" {
-		setupLoggerFile(c)
-		// Log as JSON instead of the default ASCII formatter.
-		logger.SetFormatter(&logger.JSONFormatter{})
-		logger.SetLevel(logger.InfoLevel)
-	} else {
-		logger.SetFormatter(&logger.TextFormatter{})
-		logger.SetOutput(os.Stderr)
-		logger.SetLevel(logger.DebugLevel)
+	formatter := loggerFormatter(c.LogFormat())
+	level := loggerLevel(c.LogLevel())
+	out, closers := loggerOutput(c)
+	logger.SetOutput(out)
+	closeAll(logClosers)
+	logClosers = closers
+	logger.SetFormatter(formatter)
+	logger.SetLevel(level)
+	if c.Env() != "production" && !contextHookAdded {
 		logger.AddHook(ContextHook{})
+		contextHookAdded = true
 	}
 
 	log = logger.StandardLogger()
 }
 
+// setupLogger sets up the process-wide logger with config, replacing the setup of other servers in the process,
+// "log_level" is applied on reloading config
+func (s *Server) setupLogger() {
+	initLogger(s.currentConfig())
+	s.manageLogger = true
+}
+
+// reloadLogLevel applies "log_level" in a reloaded config file to the logger
+func reloadLogLevel(c *Config) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error("reload log level failed, err=", err)
+		}
+	}()
+	level := loggerLevel(c.LogLevel())
+	if logger.GetLevel() != level {
+		log.Info("log level changed to ", level)
+		SetLevel(level)
+	}
+}
+
+// SetLevel sets logging level at runtime
+func SetLevel(level logger.Level) {
+	logger.SetLevel(level)
+}
+
 // SetOutput sets output at runtime
 func SetOutput(out io.Writer) {
 	log.Out = out
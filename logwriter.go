@@ -0,0 +1,146 @@
+package turbo
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotateWriter writes logs to a file, and rotates the file when it grows beyond maxSize,
+// or when a new rotation interval begins.
+// Rotated files are renamed to "[filename].[time]", and removed by maxBackups and maxAge.
+type rotateWriter struct {
+	mu         sync.Mutex
+	filename   string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	maxAge     time.Duration
+	file       *os.File
+	size       int64
+	openedAt   time.Time
+	now        func() time.Time
+}
+
+func newRotateWriter(filename string, maxSize int64, interval time.Duration, maxBackups int, maxAge time.Duration) (*rotateWriter, error) {
+	w := &rotateWriter{
+		filename:   filename,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: maxBackups,
+		maxAge:     maxAge,
+		now:        time.Now,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// rotateInterval parses "log_rotate_interval" into a time.Duration
+func rotateInterval(interval string) time.Duration {
+	switch interval {
+	case "":
+		return 0
+	case "hourly":
+		return time.Hour
+	case "daily":
+		return time.Hour * 24
+	default:
+		panic("invalid [log_rotate_interval]: " + interval + ", should be hourly or daily")
+	}
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotateWriter) shouldRotate(incoming int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.maxSize > 0 && w.size+incoming > w.maxSize {
+		return true
+	}
+	if w.interval > 0 && !w.now().Truncate(w.interval).Equal(w.openedAt.Truncate(w.interval)) {
+		return true
+	}
+	return false
+}
+
+func (w *rotateWriter) open() error {
+	file, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = w.now()
+	if w.size > 0 {
+		w.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+func (w *rotateWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(w.filename, w.filename+"."+w.now().Format(backupTimeFormat)); err != nil {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.removeBackups()
+	return nil
+}
+
+// removeBackups removes rotated files beyond maxBackups, or older than maxAge
+func (w *rotateWriter) removeBackups() {
+	if w.maxBackups <= 0 && w.maxAge <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(w.filename + ".*")
+	if err != nil {
+		return
+	}
+	// backup names end with a sortable timestamp, the newest comes first after sorting
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for i, b := range backups {
+		if w.maxBackups > 0 && i >= w.maxBackups {
+			os.Remove(b)
+			continue
+		}
+		if w.maxAge > 0 {
+			if info, err := os.Stat(b); err == nil && w.now().Sub(info.ModTime()) > w.maxAge {
+				os.Remove(b)
+			}
+		}
+	}
+}
+
+// Close closes the log file
+func (w *rotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
@@ -0,0 +1,103 @@
+package turbo
+
+import (
+	logger "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateWriterMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "turbolog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	w, err := newRotateWriter(dir+"/turbo.log", 10, 0, 2, 0)
+	assert.Nil(t, err)
+	defer w.Close()
+	now := time.Now()
+	w.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	for i := 0; i < 5; i++ {
+		w.Write([]byte("0123456789"))
+	}
+	backups, _ := filepath.Glob(dir + "/turbo.log.*")
+	assert.Equal(t, 2, len(backups))
+	info, err := os.Stat(dir + "/turbo.log")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), info.Size())
+}
+
+func TestRotateWriterInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "turbolog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	w, err := newRotateWriter(dir+"/turbo.log", 0, time.Hour, 0, 0)
+	assert.Nil(t, err)
+	defer w.Close()
+	now := time.Date(2017, 6, 1, 10, 30, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+	w.openedAt = now
+	w.Write([]byte("first"))
+	w.Write([]byte("second"))
+	backups, _ := filepath.Glob(dir + "/turbo.log.*")
+	assert.Equal(t, 0, len(backups))
+
+	now = now.Add(time.Hour)
+	w.Write([]byte("third"))
+	backups, _ = filepath.Glob(dir + "/turbo.log.*")
+	assert.Equal(t, 1, len(backups))
+	b, _ := ioutil.ReadFile(dir + "/turbo.log")
+	assert.Equal(t, "third", string(b))
+}
+
+func TestRotateInterval(t *testing.T) {
+	assert.Equal(t, time.Duration(0), rotateInterval(""))
+	assert.Equal(t, time.Hour, rotateInterval("hourly"))
+	assert.Equal(t, time.Hour*24, rotateInterval("daily"))
+	defer func() {
+		if err := recover(); err != nil {
+			assert.Equal(t, "invalid [log_rotate_interval]: weekly, should be hourly or daily", err)
+		} else {
+			t.Errorf("The code did not panic")
+		}
+	}()
+	rotateInterval("weekly")
+}
+
+func TestInitLoggerKeepsOutputOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "turbolog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	oldOut, oldHooks := log.Out, log.Hooks
+	defer func() {
+		closeAll(logClosers)
+		logClosers = nil
+		SetOutput(oldOut)
+		log.Hooks = oldHooks
+		contextHookAdded = false
+	}()
+	log.Hooks = make(logger.LevelHooks)
+
+	c := NewConfig("grpc", "test/service_test.yaml")
+	c.configs[environment] = "development"
+	c.configs[logOutput] = "file"
+	c.configs[turboLogPath] = dir
+	initLogger(c)
+	initLogger(c)
+	assert.Equal(t, 1, len(log.Hooks[logger.InfoLevel]))
+
+	ioutil.WriteFile(dir+"/notadir", nil, 0644)
+	c.configs[turboLogPath] = dir + "/notadir"
+	assert.Panics(t, func() { initLogger(c) })
+	log.Warn("still logged")
+	data, err := ioutil.ReadFile(dir + "/turbo.log")
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "still logged")
+}
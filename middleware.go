@@ -0,0 +1,68 @@
+package turbo
+
+import (
+	"net/http"
+)
+
+// Middleware is a standard net/http middleware, it can wrap the ResponseWriter, or respond without calling next.
+// A Middleware is used in the same places as Interceptors, in "global_interceptor", "interceptor",
+// and "interceptors" of routes, e.g.
+//
+//	global_interceptor: RequestID,LogInterceptor
+//	interceptor:
+//	  - GET,POST /api/ Gzip,AuthInterceptor
+//
+// it wraps the rest of the chain at its position, so Interceptors before it run Before() earlier,
+// and After() later, and it's ordered by priorities, and excluded with "!Name", as Interceptors are.
+type Middleware func(next http.Handler) http.Handler
+
+// Before is empty, a Middleware runs when the chain is built
+func (m Middleware) Before(http.ResponseWriter, *http.Request) error { return nil }
+
+// After is empty, a Middleware runs when the chain is built
+func (m Middleware) After(http.ResponseWriter, *http.Request) error { return nil }
+
+// chain nests interceptors around h in order, a Middleware wraps the rest of the chain,
+// and an Interceptor runs Before() before the rest of the chain, and After() after it.
+func chain(interceptors []Interceptor, h http.Handler) http.Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		if m, ok := interceptors[i].(Middleware); ok {
+			h = m(h)
+		} else {
+			h = interceptorHandler(interceptors[i], h)
+		}
+	}
+	return h
+}
+
+// interceptorHandler runs i.Before(), next, then i.After(),
+// if Before() returns an error, it's handled by the error handler, and neither next nor After() runs.
+func interceptorHandler(i Interceptor, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if err := i.Before(resp, req); err != nil {
+			log.Errorln("error in Before(): ", err.Error())
+			components(req).errorHandlerFunc()(resp, req, err)
+			return
+		}
+		next.ServeHTTP(resp, req)
+		if err := i.After(resp, req); err != nil {
+			log.Errorln("turbo: error in After(): ", err.Error())
+		}
+	})
+}
+
+// Handler loads components and routes of s, and returns an http.Handler which serves them,
+// so that turbo can be mounted in an existing server, e.g.
+//
+//	mux.Handle("/api/", http.StripPrefix("/api", turbo.Handler(s)))
+//
+// the handler keeps serving with the latest routes after config is reloaded.
+func Handler(s Servable) http.Handler {
+	sf := s.ServerField()
+	c := sf.currentConfig()
+	components := sf.loadComponents(c)
+	r, err := router(s, c)
+	panicIf(err)
+	sf.swapIfCurrent(c, components, r)
+	return http.HandlerFunc(sf.serveHTTP)
+}
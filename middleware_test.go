@@ -0,0 +1,111 @@
+package turbo
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type traceInterceptor struct {
+	name  string
+	trace *bytes.Buffer
+	err   error
+}
+
+func (i *traceInterceptor) Before(resp http.ResponseWriter, req *http.Request) error {
+	i.trace.WriteString(i.name + ".before ")
+	return i.err
+}
+
+func (i *traceInterceptor) After(resp http.ResponseWriter, req *http.Request) error {
+	i.trace.WriteString(i.name + ".after ")
+	return nil
+}
+
+// upperWriter is a ResponseWriter wrapped by a middleware
+type upperWriter struct {
+	http.ResponseWriter
+}
+
+func (w upperWriter) Write(p []byte) (int, error) {
+	return w.ResponseWriter.Write(bytes.ToUpper(p))
+}
+
+func TestMiddleware(t *testing.T) {
+	trace := new(bytes.Buffer)
+	s := setupRoutedServer(t, `config:
+  http_port: 8081
+global_interceptor: Outer,Upper
+urlmapping:
+  - GET /hello SayHello
+  - GET /blocked SayHello
+  - GET /failed SayHello
+hijacker:
+  - GET /hello hijacker
+  - GET /blocked hijacker
+  - GET /failed hijacker
+interceptor:
+  - GET /hello Inner
+  - GET /blocked Block,Inner
+  - GET /failed Failed,Inner
+`, func(s *Server) {
+		s.RegisterInterceptor("Outer", &traceInterceptor{name: "outer", trace: trace})
+		s.RegisterInterceptor("Inner", &traceInterceptor{name: "inner", trace: trace})
+		s.RegisterInterceptor("Failed", &traceInterceptor{name: "failed", trace: trace, err: errors.New("failed")})
+		s.RegisterMiddleware("Upper", func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+				trace.WriteString("upper.begin ")
+				next.ServeHTTP(upperWriter{resp}, req)
+				trace.WriteString("upper.end ")
+			})
+		})
+		s.RegisterComponent("Block", func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+				http.Error(resp, "blocked", http.StatusTooManyRequests)
+			})
+		})
+	})
+
+	w := routedRequest(s, "GET", "/hello", nil)
+	assert.Equal(t, "HIJACKED", w.Body.String())
+	assert.Equal(t, "outer.before upper.begin inner.before inner.after upper.end outer.after ", trace.String())
+
+	trace.Reset()
+	w = routedRequest(s, "GET", "/blocked", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "BLOCKED\n", w.Body.String())
+	assert.Equal(t, "outer.before upper.begin upper.end outer.after ", trace.String())
+
+	trace.Reset()
+	w = routedRequest(s, "GET", "/failed", nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "outer.before upper.begin failed.before upper.end outer.after ", trace.String())
+}
+
+func TestHandler(t *testing.T) {
+	s := &GrpcServer{
+		Server: &Server{Config: NewConfigFromSource("grpc", NewStaticSource("test", []byte(`config:
+  http_port: 8081
+urlmapping:
+  - GET /hello SayHello
+hijacker:
+  - GET /hello hijacker
+`))), Components: new(Components)},
+		gClient: &grpcClient{grpcService: &testServiceClient{}},
+	}
+	s.RegisterHijacker("hijacker", func(resp http.ResponseWriter, req *http.Request) { resp.Write([]byte("hijacked")) })
+	mux := http.NewServeMux()
+	mux.Handle("/api/", http.StripPrefix("/api", Handler(s)))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/hello", nil)
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, "hijacked", w.Body.String())
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/hello", nil)
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,306 @@
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cast"
+	"strings"
+	"time"
+)
+
+const routesKey = "routes"
+
+// Route is an entry in "routes", a structured alternative to "urlmapping" and component lines, e.g.
+//
+//	routes:
+//	  - method: GET,POST
+//	    path: /hello
+//	    rpc: SayHello
+//	    interceptors: [LogInterceptor]
+//	    preprocessor: [checkName, checkAge]
+//	    postprocessor: [addTimestamp, writeResponse]
+//	    hijacker: hijacker
+//	    timeout: 3s
+//	    cache:
+//	      ttl: 30s
+//	      headers: [Accept-Language]
+//	    coalesce: true
+//	    max_body_size: 10MB
+//	    compress: true
+//	    cors:
+//	      allowed_origins: [https://example.com]
+//	    authorization:
+//	      roles: [admin]
+type Route struct {
+	Method string
+	Path   string
+	RPC    string
+	// Interceptors are names of Interceptors or Middlewares
+	Interceptors []string
+	// Preprocessors run in order
+	Preprocessors []string
+	// Postprocessors are ResponseTransformers in order, optionally followed by a Postprocessor
+	Postprocessors []string
+	Hijacker       string
+	// Timeout is set to the request context, 0 means no timeout
+	Timeout time.Duration
+	// Cache caches responses of GET requests, nil means no cache
+	Cache *CachePolicy
+	// Coalesce collapses identical concurrent calls into one backend call
+	Coalesce bool
+	// MaxBodySize is the max size of request bodies in bytes, 0 means "max_body_size" in config is used
+	MaxBodySize int64
+	// Compress turns compression of the route on or off, nil means "compression" in config is used
+	Compress *bool
+	// CORS replaces the global CORS policy for the path, nil means the global policy is used
+	CORS *CORSPolicy
+	// Authorization is checked for the route, Methods and Path of it are set from the route
+	Authorization *AuthzRule
+}
+
+var routeFields = []string{"method", "path", "rpc", "interceptors", "preprocessor", "postprocessor", "hijacker", "timeout", "cache", "coalesce", "max_body_size", "compress", "cors", "authorization"}
+
+// loadRoutes loads "routes", and appends them to mappings loaded from lines,
+// it panics with the position of the entry if any of them is invalid.
+func (c *Config) loadRoutes() {
+	raw := c.Get(routesKey)
+	if raw == nil {
+		return
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		panic(fmt.Sprintf("invalid [%s], should be a list, got: %v", routesKey, raw))
+	}
+	for i, item := range list {
+		r, err := parseRoute(item)
+		if err != nil {
+			text := "path: " + cast.ToString(cast.ToStringMap(item)["path"])
+			panic(fmt.Sprintf("%s: invalid [%s] #%d, %s", c.position(routesKey, i, text), routesKey, i+1, err))
+		}
+		c.addRoute(r)
+	}
+}
+
+// addRoute adds r to mappings
+func (c *Config) addRoute(r Route) {
+	c.mappings[urlServiceMaps] = append(c.mappings[urlServiceMaps], [3]string{r.Method, r.Path, r.RPC})
+	if len(r.Interceptors) > 0 {
+		c.mappings[interceptors] = append(c.mappings[interceptors],
+			[3]string{r.Method, r.Path, strings.Join(r.Interceptors, ",")})
+	}
+	if len(r.Preprocessors) > 0 {
+		c.mappings[preprocessors] = append(c.mappings[preprocessors],
+			[3]string{r.Method, r.Path, strings.Join(r.Preprocessors, ",")})
+	}
+	if len(r.Postprocessors) > 0 {
+		c.mappings[postprocessors] = append(c.mappings[postprocessors],
+			[3]string{r.Method, r.Path, strings.Join(r.Postprocessors, ",")})
+	}
+	if len(r.Hijacker) > 0 {
+		c.mappings[hijackers] = append(c.mappings[hijackers], [3]string{r.Method, r.Path, r.Hijacker})
+	}
+	if r.Timeout > 0 {
+		c.timeouts[r.Method+" "+r.Path] = r.Timeout
+	}
+	if r.Cache != nil {
+		c.caches[r.Method+" "+r.Path] = *r.Cache
+	}
+	if r.Coalesce {
+		c.coalesced[r.Method+" "+r.Path] = true
+	}
+	if r.MaxBodySize > 0 {
+		c.bodyLimits[r.Method+" "+r.Path] = r.MaxBodySize
+	}
+	if r.Compress != nil {
+		c.compressed[r.Method+" "+r.Path] = *r.Compress
+	}
+	if r.CORS != nil {
+		c.cors[r.Path] = r.CORS
+	}
+	if r.Authorization != nil {
+		rule := *r.Authorization
+		rule.Methods, rule.Path = strings.Split(r.Method, ","), r.Path
+		c.authz = append(c.authz, rule)
+	}
+}
+
+// routeTimeout returns the timeout of the route with methods and path, 0 if not set
+func (c *Config) routeTimeout(methods, path string) time.Duration {
+	return c.timeouts[methods+" "+path]
+}
+
+func parseRoute(item interface{}) (r Route, err error) {
+	m, err := cast.ToStringMapE(item)
+	if err != nil {
+		return r, errors.New("should be a map with keys: " + strings.Join(routeFields, ", "))
+	}
+	if err = checkKeys(m, routeFields); err != nil {
+		return r, err
+	}
+	if r.Method, err = parseMethods(m["method"]); err != nil {
+		return r, errors.New("[method] should be a list of methods, or methods separated by ','")
+	}
+	r.Path = strings.TrimSpace(cast.ToString(m["path"]))
+	r.RPC = strings.TrimSpace(cast.ToString(m["rpc"]))
+	r.Hijacker = strings.TrimSpace(cast.ToString(m["hijacker"]))
+	if r.Interceptors, err = parseNames(m["interceptors"]); err != nil {
+		return r, errors.New("[interceptors] should be a list of names, or names separated by ','")
+	}
+	if r.Preprocessors, err = parseNames(m["preprocessor"]); err != nil {
+		return r, errors.New("[preprocessor] should be a list of names, or names separated by ','")
+	}
+	if r.Postprocessors, err = parseNames(m["postprocessor"]); err != nil {
+		return r, errors.New("[postprocessor] should be a list of names, or names separated by ','")
+	}
+	if timeout := strings.TrimSpace(cast.ToString(m["timeout"])); len(timeout) > 0 {
+		if r.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return r, fmt.Errorf("[timeout] should be a duration like \"5s\", got: %s", timeout)
+		}
+	}
+	if coalesce, ok := m["coalesce"]; ok {
+		if r.Coalesce, err = cast.ToBoolE(coalesce); err != nil {
+			return r, fmt.Errorf("[coalesce] should be true or false, got: %v", coalesce)
+		}
+	}
+	if size := strings.TrimSpace(cast.ToString(m["max_body_size"])); len(size) > 0 {
+		if r.MaxBodySize, err = parseSize(size); err != nil {
+			return r, fmt.Errorf("[max_body_size] should be a size like \"10MB\", got: %s", size)
+		}
+	}
+	if compress, ok := m["compress"]; ok {
+		on, err := cast.ToBoolE(compress)
+		if err != nil {
+			return r, fmt.Errorf("[compress] should be true or false, got: %v", compress)
+		}
+		r.Compress = &on
+	}
+	if cors, ok := m["cors"]; ok {
+		if r.CORS, err = parseRouteCORS(cors); err != nil {
+			return r, err
+		}
+	}
+	if authz, ok := m["authorization"]; ok {
+		if r.Authorization, err = parseRouteAuthz(authz); err != nil {
+			return r, err
+		}
+	}
+	if cache, ok := m["cache"]; ok {
+		if r.Cache, err = parseCachePolicy(cache); err != nil {
+			return r, err
+		}
+	}
+	return r, r.validate()
+}
+
+// parseRouteCORS parses a map with keys in corsFields
+func parseRouteCORS(v interface{}) (*CORSPolicy, error) {
+	m, err := cast.ToStringMapE(v)
+	if err != nil {
+		return nil, errors.New("[cors] should be a map with keys: " + strings.Join(corsFields, ", "))
+	}
+	if err = checkKeys(m, corsFields); err != nil {
+		return nil, errors.New("[cors] has " + err.Error())
+	}
+	p, err := parseCORSPolicy(m)
+	if err != nil {
+		return nil, errors.New("[cors] " + err.Error())
+	}
+	return p, nil
+}
+
+// parseRouteAuthz parses a map with keys in authzFields, except path, rpc and methods
+func parseRouteAuthz(v interface{}) (*AuthzRule, error) {
+	fields := authzFields[3:]
+	m, err := cast.ToStringMapE(v)
+	if err != nil {
+		return nil, errors.New("[authorization] should be a map with keys: " + strings.Join(fields, ", "))
+	}
+	r, err := parseAuthzRule(m, fields)
+	if err != nil {
+		return nil, errors.New("[authorization] " + err.Error())
+	}
+	return &r, nil
+}
+
+// parseCachePolicy parses a map like {ttl: 30s, headers: [Accept-Language]}
+func parseCachePolicy(v interface{}) (*CachePolicy, error) {
+	m, err := cast.ToStringMapE(v)
+	if err != nil {
+		return nil, errors.New("[cache] should be a map with keys: ttl, headers")
+	}
+	ttl, err := time.ParseDuration(strings.TrimSpace(cast.ToString(m["ttl"])))
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("[cache.ttl] should be a duration like \"30s\", got: %v", m["ttl"])
+	}
+	p := &CachePolicy{TTL: ttl}
+	if p.Headers, err = parseNames(m["headers"]); err != nil {
+		return nil, errors.New("[cache.headers] should be a list of names, or names separated by ','")
+	}
+	return p, nil
+}
+
+func (r Route) validate() error {
+	if len(r.Method) == 0 {
+		return errors.New("[method] is required")
+	}
+	for _, m := range strings.Split(r.Method, ",") {
+		if len(m) == 0 {
+			return errors.New("[method] has an empty method: " + r.Method)
+		}
+	}
+	if !strings.HasPrefix(r.Path, "/") {
+		return errors.New("[path] should start with '/', got: " + r.Path)
+	}
+	if len(r.RPC) == 0 {
+		return errors.New("[rpc] is required")
+	}
+	if r.Cache != nil && !contains(strings.Split(r.Method, ","), "GET") {
+		return errors.New("[cache] only works with GET, got: " + r.Method)
+	}
+	return nil
+}
+
+// parseMethods parses a list of methods, or methods separated by ',', into upper case methods separated by ','
+func parseMethods(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return strings.ToUpper(strings.Replace(s, " ", "", -1)), nil
+	}
+	methods, err := parseNames(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(strings.Join(methods, ",")), nil
+}
+
+// parseNames parses a list of names, or names separated by ','
+func parseNames(v interface{}) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	var names []string
+	if s, ok := v.(string); ok {
+		names = strings.Split(s, ",")
+	} else {
+		list, err := cast.ToStringSliceE(v)
+		if err != nil {
+			return nil, err
+		}
+		names = list
+	}
+	result := make([]string, 0, len(names))
+	for _, n := range names {
+		if n = strings.TrimSpace(n); len(n) > 0 {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -2,7 +2,6 @@ package turbo
 
 import (
 	"errors"
-	// TODO support logging levels, log file path, etc.
 	"bytes"
 	"context"
 	"encoding/json"
@@ -17,21 +16,75 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type switcher func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error)
 
-var switcherFunc switcher
+// errNoSwitcher is returned when a request is mapped to a service method, but no switcher is set
+var errNoSwitcher = errors.New("turbo: no switcher to call service methods, set it with WithClient()")
 
-func router(s Servable) *mux.Router {
+func router(s Servable, c *Config) (*mux.Router, error) {
 	r := mux.NewRouter()
-	for _, v := range s.ServerField().Config.mappings[urlServiceMaps] {
+	// methods mapped to each path, preflight requests are handled for paths with a CORS policy
+	paths := make([]string, 0)
+	pathMethods := make(map[string][]string)
+	var compress *CompressionPolicy
+	for _, v := range c.mappings[urlServiceMaps] {
 		httpMethods := strings.Split(v[0], ",")
 		path := v[1]
 		methodName := v[2]
-		r.HandleFunc(path, handler(s, methodName)).Methods(httpMethods...)
+		call := callHandler(s, methodName)
+		if p, ok := c.routeCache(v[0], v[1]); ok {
+			// responses are cached after interceptors and authorization, which run on cache hits as well
+			call = withCache(call, s.ServerField().cache, p)
+		}
+		h := handler(s, methodName, call)
+		if timeout := c.routeTimeout(v[0], v[1]); timeout > 0 {
+			h = withTimeout(h, timeout)
+		}
+		if c.coalesced[v[0]+" "+v[1]] {
+			h = withCoalescing(h, new(flightGroup))
+		}
+		if limit := c.routeBodyLimit(v[0], v[1]); limit > 0 {
+			h = withBodyLimit(h, limit)
+		}
+		if c.routeCompression(v[0], v[1]) {
+			if compress == nil {
+				var err error
+				if compress, err = c.compressionPolicy(); err != nil {
+					return nil, err
+				}
+			}
+			h = withCompression(h, compress)
+		}
+		if p := c.corsPolicy(path); p != nil {
+			h = withCORS(h, p)
+		}
+		route := r.HandleFunc(path, h).Methods(httpMethods...)
+		if err := route.GetError(); err != nil {
+			return nil, fmt.Errorf("turbo: invalid urlmapping: %s %s %s, error: %s", v[0], v[1], v[2], err)
+		}
+		if _, ok := pathMethods[path]; !ok {
+			paths = append(paths, path)
+		}
+		pathMethods[path] = append(pathMethods[path], httpMethods...)
+	}
+	for _, path := range paths {
+		if p := c.corsPolicy(path); p != nil && !contains(pathMethods[path], "OPTIONS") {
+			r.HandleFunc(path, preflightHandler(p, pathMethods[path])).Methods("OPTIONS")
+		}
+	}
+	return r, nil
+}
+
+// withTimeout sets a timeout to the request context, rpc calls with this context are cancelled on timeout
+func withTimeout(h func(http.ResponseWriter, *http.Request), timeout time.Duration) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		h(resp, req.WithContext(ctx))
 	}
-	return r
 }
 
 type key int
@@ -42,7 +95,7 @@ var componentsKey key = 0
 // we are using the same Components through out one request lifecycle.
 // Server.Components may change on reloading config.
 func copyComponentsPtr(s Servable, req *http.Request) {
-	ctx := context.WithValue(req.Context(), componentsKey, s.ServerField().Components)
+	ctx := context.WithValue(req.Context(), componentsKey, s.ServerField().currentComponents())
 	*req = *req.WithContext(ctx)
 }
 
@@ -50,39 +103,33 @@ func components(req *http.Request) *Components {
 	return req.Context().Value(componentsKey).(*Components)
 }
 
-func handler(s Servable, methodName string) func(http.ResponseWriter, *http.Request) {
+// handler runs interceptors and checks authorization for methodName, then serves the request with call
+func handler(s Servable, methodName string, call func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(resp http.ResponseWriter, req *http.Request) {
 		copyComponentsPtr(s, req)
-		parseRequestForm(req)
-		interceptors := getInterceptors(s, req)
-		req, err := doBefore(&interceptors, resp, req)
-		if err == nil {
-			doRequest(s, methodName, resp, req)
-		} else {
+		if err := parseRequestForm(req); err == errBodyTooLarge {
 			components(req).errorHandlerFunc()(resp, req, err)
+			return
 		}
-		doAfter(interceptors, resp, req)
+		chain(getInterceptors(s, req), http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			if err := components(req).checkAuthorization(req, methodName); err != nil {
+				components(req).errorHandlerFunc()(resp, req, err)
+				return
+			}
+			call(resp, req)
+		})).ServeHTTP(resp, req)
 	}
 }
 
-func getInterceptors(s Servable, req *http.Request) []Interceptor {
-	interceptors := components(req).Interceptors(req)
-	if len(interceptors) == 0 {
-		interceptors = components(req).CommonInterceptors()
+// callHandler calls methodName of the backend service for a request which is authorized
+func callHandler(s Servable, methodName string) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		doRequest(s, methodName, resp, req)
 	}
-	return interceptors
 }
 
-func doBefore(interceptors *[]Interceptor, resp http.ResponseWriter, req *http.Request) (request *http.Request, err error) {
-	for index, i := range *interceptors {
-		err = i.Before(resp, req)
-		if err != nil {
-			log.Errorln("error in Before(): ", err.Error())
-			*interceptors = (*interceptors)[0:index]
-			return req, err
-		}
-	}
-	return req, nil
+func getInterceptors(s Servable, req *http.Request) []Interceptor {
+	return components(req).Interceptors(req)
 }
 
 func doRequest(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) {
@@ -95,7 +142,12 @@ func doRequest(s Servable, methodName string, resp http.ResponseWriter, req *htt
 		components(req).errorHandlerFunc()(resp, req, err)
 		return
 	}
-	serviceResp, err := switcherFunc(s, methodName, resp, req)
+	sw := s.ServerField().switcher
+	if sw == nil {
+		components(req).errorHandlerFunc()(resp, req, errNoSwitcher)
+		return
+	}
+	serviceResp, err := sw(s, methodName, resp, req)
 	if err != nil {
 		components(req).errorHandlerFunc()(resp, req, err)
 		return
@@ -155,6 +207,19 @@ func doPreprocessor(s Servable, resp http.ResponseWriter, req *http.Request) err
 }
 
 func doPostprocessor(s Servable, resp http.ResponseWriter, req *http.Request, serviceResponse interface{}, err error) {
+	// run ResponseTransformers in order, each of them gets the result of the former one
+	if err == nil {
+		for _, t := range components(req).ResponseTransformers(req) {
+			transformed, transformErr := t(resp, req, serviceResponse)
+			if transformErr != nil {
+				log.Println(transformErr.Error())
+				components(req).errorHandlerFunc()(resp, req, transformErr)
+				return
+			}
+			serviceResponse = transformed
+		}
+	}
+
 	// run Postprocessor, if any
 	post := components(req).Postprocessor(req)
 	if post != nil {
@@ -163,10 +228,11 @@ func doPostprocessor(s Servable, resp http.ResponseWriter, req *http.Request, se
 	}
 
 	// return as json
+	c := s.ServerField().currentConfig()
 	m := Marshaler{
-		FilterProtoJson: s.ServerField().Config.FilterProtoJson(),
-		EmitZeroValues:  s.ServerField().Config.FilterProtoJsonEmitZeroValues(),
-		Int64AsNumber:   s.ServerField().Config.FilterProtoJsonInt64AsNumber(),
+		FilterProtoJson: c.FilterProtoJson(),
+		EmitZeroValues:  c.FilterProtoJsonEmitZeroValues(),
+		Int64AsNumber:   c.FilterProtoJsonInt64AsNumber(),
 	}
 	jsonBytes, err := m.JSON(serviceResponse)
 	if err == nil {
@@ -178,17 +244,6 @@ func doPostprocessor(s Servable, resp http.ResponseWriter, req *http.Request, se
 	}
 }
 
-func doAfter(interceptors []Interceptor, resp http.ResponseWriter, req *http.Request) (err error) {
-	l := len(interceptors)
-	for i := l - 1; i >= 0; i-- {
-		err = interceptors[i].After(resp, req)
-		if err != nil {
-			log.Errorln("turbo: error in After(): ", err.Error())
-		}
-	}
-	return nil
-}
-
 //BuildStruct finds values from request, and set them to struct fields recursively
 func BuildStruct(s Servable, theType reflect.Type, theValue reflect.Value, req *http.Request) {
 	if theValue.Kind() == reflect.Invalid {
@@ -426,7 +481,12 @@ func reflectSliceValue(fieldType reflect.Type, fieldValue reflect.Value, v strin
 	return s, nil
 }
 
+// findValue returns the value of fieldName in claims mapped by "auth_claim_fields", the request form, or the context,
+// a verified claim takes precedence, so that it can't be spoofed by a parameter with the same name.
 func findValue(fieldName string, req *http.Request) (string, bool) {
+	if v, ok := findAuthValue(fieldName, req); ok {
+		return v, true
+	}
 	lowerCasesName := strings.ToLower(fieldName)
 	snakeCaseName := ToSnakeCase(fieldName)
 
@@ -457,7 +517,9 @@ func BuildRequest(s Servable, v proto.Message, req *http.Request) error {
 	var err error
 	if contentTypes, ok := req.Header["Content-Type"]; ok && contentTypes[0] == "application/json" {
 		buf := new(bytes.Buffer)
-		buf.ReadFrom(req.Body)
+		if _, err := buf.ReadFrom(req.Body); err != nil {
+			return err
+		}
 		bodyStr := buf.String()
 		unmarshaler := &jsonpb.Unmarshaler{AllowUnknownFields: true}
 		err = unmarshaler.Unmarshal(strings.NewReader(bodyStr), v)
@@ -466,6 +528,7 @@ func BuildRequest(s Servable, v proto.Message, req *http.Request) error {
 				"request body: %s, error: %s", bodyStr, err))
 		}
 		setPathParams(reflect.TypeOf(v).Elem(), reflect.ValueOf(v).Elem(), req)
+		setAuthFields(reflect.TypeOf(v).Elem(), reflect.ValueOf(v).Elem(), req)
 	} else {
 		BuildStruct(s, reflect.TypeOf(v).Elem(), reflect.ValueOf(v).Elem(), req)
 	}
@@ -477,7 +540,9 @@ func BuildThriftRequest(s Servable, args interface{}, req *http.Request, buildSt
 	var params []reflect.Value
 	if contentTypes, ok := req.Header["Content-Type"]; ok && contentTypes[0] == "application/json" {
 		buf := new(bytes.Buffer)
-		buf.ReadFrom(req.Body)
+		if _, err := buf.ReadFrom(req.Body); err != nil {
+			return params, err
+		}
 		v := reflect.New(reflect.ValueOf(args).Field(0).Type().Elem()).Interface()
 		err := json.Unmarshal(buf.Bytes(), v)
 		// TODO [2] refactor error, define own errors?
@@ -486,6 +551,7 @@ func BuildThriftRequest(s Servable, args interface{}, req *http.Request, buildSt
 				"request body: %s, error: %s", buf.String(), err))
 		}
 		setPathParams(reflect.TypeOf(v).Elem(), reflect.ValueOf(v).Elem(), req)
+		setAuthFields(reflect.TypeOf(v).Elem(), reflect.ValueOf(v).Elem(), req)
 		params = make([]reflect.Value, 1)
 		params[0] = reflect.ValueOf(v)
 	} else {
@@ -495,16 +561,36 @@ func BuildThriftRequest(s Servable, args interface{}, req *http.Request, buildSt
 }
 
 func setPathParams(theType reflect.Type, theValue reflect.Value, req *http.Request) {
-	fieldNum := theType.NumField()
 	pathParams := mux.Vars(req)
+	setFieldValues(theType, theValue, func(fieldName string) (string, bool) {
+		return findPathParamValue(fieldName, pathParams)
+	})
+}
+
+// setAuthFields sets claims mapped by "auth_claim_fields" to fields of a request built from a JSON body,
+// they override values in the body.
+func setAuthFields(theType reflect.Type, theValue reflect.Value, req *http.Request) {
+	if len(authFields(req)) == 0 {
+		return
+	}
+	setFieldValues(theType, theValue, func(fieldName string) (string, bool) {
+		return findAuthValue(fieldName, req)
+	})
+}
+
+// setFieldValues sets values returned by find to fields of theValue, and fields of its nested structs
+func setFieldValues(theType reflect.Type, theValue reflect.Value, find func(fieldName string) (string, bool)) {
+	fieldNum := theType.NumField()
 	for i := 0; i < fieldNum; i++ {
 		fieldName := theType.Field(i).Name
 		fieldValue := theValue.FieldByName(fieldName)
 		if fieldValue.Kind() == reflect.Ptr && fieldValue.Type().Elem().Kind() == reflect.Struct {
-			setPathParams(fieldValue.Type().Elem(), fieldValue.Elem(), req)
+			if !fieldValue.IsNil() {
+				setFieldValues(fieldValue.Type().Elem(), fieldValue.Elem(), find)
+			}
 			continue
 		}
-		v, ok := findPathParamValue(fieldName, pathParams)
+		v, ok := find(fieldName)
 		if !ok {
 			continue
 		}
@@ -3,23 +3,21 @@ package turbo
 import (
 	"context"
 	"errors"
-	"git.apache.org/thrift.git/lib/go/thrift"
+	"fmt"
 	"github.com/fsnotify/fsnotify"
 	"github.com/gorilla/mux"
-	"github.com/spf13/viper"
 	"google.golang.org/grpc"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"reflect"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
-// TODO Make Ctrl+C cancel the context.Context
-// https://medium.com/@matryer/make-ctrl-c-cancel-the-context-context-bd006a8ad6ff
-
 type Servable interface {
 	Service() interface{}
 	ServerField() *Server
@@ -32,10 +30,59 @@ type Server struct {
 	Config *Config
 	// Components holds the mappings of url to component
 	Components   *Components
-	reloadConfig chan bool
-	exit         chan os.Signal
+	reloadConfig chan *Config
+	// reloadMu serializes sending to reloadConfig, which holds at most one pending config
+	reloadMu sync.Mutex
+	exit     chan os.Signal
+	// upgrade receives SIGUSR2, which triggers a graceful restart
+	upgrade chan os.Signal
 	// Initializer implements Initializable
 	Initializer Initializable
+	adminServer *http.Server
+	ready       int32
+	ctx         context.Context
+	cancel      context.CancelFunc
+	// mu guards Config, Components, router and httpServer, which are replaced on reloading config
+	mu          sync.RWMutex
+	router      *mux.Router
+	httpServer  *http.Server
+	reloadHooks []ReloadHook
+	// routes are added by AddRoute()
+	routes []Route
+	// cacheStore holds cached responses of routes with a cache policy
+	cacheStore CacheStore
+	// inflight is the number of HTTP requests being served
+	inflight int64
+	// switcher calls methods of the backend service client
+	switcher switcher
+	// httpAddr is the address HTTP server listens on, it's set by Start()
+	httpAddr net.Addr
+	// manageLogger is true if the process-wide logger is set up by this server,
+	// an embedded server leaves the logger to the program it's embedded in.
+	manageLogger bool
+	// started is set to 1 by Start(), a server can be started only once
+	started      int32
+	reloadOnce   sync.Once
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+// rpcServer is implemented by GrpcServer and ThriftServer, which serve rpc services
+// and hold clients to backend services.
+type rpcServer interface {
+	// startDraining is called when the drain period begins
+	startDraining()
+	// closeClient closes the connection to backend service
+	closeClient() error
+	// stopService stops the rpc service, and blocks until in-flight calls complete, or ctx is done
+	stopService(ctx context.Context)
+	// reloadEndpoints moves the rpc service and the backend connection to the addresses in c,
+	// and applies other settings of the rpc service in c
+	reloadEndpoints(current, c *Config) error
+	// connectClient connects to backend service with the client set by WithClient(), if any
+	connectClient()
+	// client returns the backend service client, nil if it's not connected
+	client() interface{}
 }
 
 func (s *Server) Service() interface{} { return nil }
@@ -47,160 +94,609 @@ func (s *Server) Stop() { return }
 // RegisterComponent registers a component,
 // The convention is to register with the name of that component,
 // the name is used in config file to look up for a component.
+// It panics if component is not an Interceptor, Preprocessor, Postprocessor, ResponseTransformer,
+// Hijacker, Convertor, ErrorHandlerFunc, AuthzPolicy, Middleware or ThriftInterceptor,
+// prefer the typed RegisterXxx() funcs, which are checked at compile time.
 func (s *Server) RegisterComponent(name string, component interface{}) {
+	c, err := asComponent(component)
+	if err != nil {
+		panic(fmt.Errorf("turbo: failed to register component [%s], error: %s", name, err))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.Components.registeredComponents == nil {
 		s.Components.registeredComponents = make(map[string]interface{})
 	}
-	s.Components.registeredComponents[name] = component
+	s.Components.registeredComponents[name] = c
+}
+
+// RegisterInterceptor registers an Interceptor with name
+func (s *Server) RegisterInterceptor(name string, i Interceptor) { s.RegisterComponent(name, i) }
+
+// RegisterPreprocessor registers a Preprocessor with name
+func (s *Server) RegisterPreprocessor(name string, p Preprocessor) { s.RegisterComponent(name, p) }
+
+// RegisterPostprocessor registers a Postprocessor with name
+func (s *Server) RegisterPostprocessor(name string, p Postprocessor) { s.RegisterComponent(name, p) }
+
+// RegisterResponseTransformer registers a ResponseTransformer with name
+func (s *Server) RegisterResponseTransformer(name string, t ResponseTransformer) {
+	s.RegisterComponent(name, t)
+}
+
+// RegisterHijacker registers a Hijacker with name
+func (s *Server) RegisterHijacker(name string, h Hijacker) { s.RegisterComponent(name, h) }
+
+// RegisterConvertor registers a Convertor with name
+func (s *Server) RegisterConvertor(name string, c Convertor) { s.RegisterComponent(name, c) }
+
+// RegisterErrorHandler registers an ErrorHandlerFunc with name
+func (s *Server) RegisterErrorHandler(name string, e ErrorHandlerFunc) { s.RegisterComponent(name, e) }
+
+// RegisterAuthzPolicy registers an AuthzPolicy with name
+func (s *Server) RegisterAuthzPolicy(name string, p AuthzPolicy) { s.RegisterComponent(name, p) }
+
+// RegisterMiddleware registers a Middleware with name, it's used in config as an Interceptor
+func (s *Server) RegisterMiddleware(name string, m Middleware) { s.RegisterComponent(name, m) }
+
+// RegisterThriftInterceptor registers a ThriftInterceptor with name, it's used in "thrift_interceptor"
+func (s *Server) RegisterThriftInterceptor(name string, i ThriftInterceptor) {
+	s.RegisterComponent(name, i)
+}
+
+// AddRoute adds a route in code, with the same semantics as an entry in "routes" of config file,
+// components in r are looked up by name in registered components.
+// Routes added are kept when config is reloaded, call it before starting the server.
+func (s *Server) AddRoute(r Route) error {
+	if err := r.validate(); err != nil {
+		return fmt.Errorf("turbo: invalid route %s %s, %s", r.Method, r.Path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = append(s.routes, r)
+	s.Config.addRoute(r)
+	return nil
+}
+
+// addRoutes adds routes added by AddRoute() to c
+func (s *Server) addRoutes(c *Config) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.routes {
+		c.addRoute(r)
+	}
 }
 
 // Component returns a component by name.
 func (s *Server) Component(name string) (interface{}, error) {
-	if s.Components.registeredComponents[name] == nil {
+	c := s.currentComponents().registeredComponents[name]
+	if c == nil {
 		return nil, errors.New("no such component: " + name + ", forget to register?")
 	}
-	return s.Components.registeredComponents[name], nil
+	return c, nil
 }
 
+// watchConfig reloads config when the config file or Config.Source is changed
 func (s *Server) watchConfig() {
-	s.Config.WatchConfig()
-	s.Config.OnConfigChange(func(e fsnotify.Event) {
-		c := &Config{
-			Viper:    *viper.New(),
-			File:     s.Config.File,
-			mappings: make(map[string][][3]string)}
-		c.loadServiceConfig()
-		s.Config = c
-		s.reloadConfig <- true
+	c := s.currentConfig()
+	if source := c.Source; source != nil {
+		go source.Watch(s.ctx.Done(), func() {
+			logErrorIf(s.ReloadConfig())
+		})
+		return
+	}
+	c.WatchConfig()
+	c.OnConfigChange(func(e fsnotify.Event) {
+		logErrorIf(s.ReloadConfig())
 	})
 }
 
+// errReloadNotSupported is returned by ReloadConfig() if the server is not created by a constructor,
+// which makes the channel of reloading, or if it only serves the backend service, which has nothing to reload.
+var errReloadNotSupported = errors.New("turbo: the config can't be reloaded, the server is not created by a constructor, or serves no HTTP")
+
+// ReloadConfig reads the config file or Config.Source again, the new config is validated and applied to the running server
+// asynchronously, the current config is kept if the new one is invalid.
+// Use OnReload() to get the result.
+func (s *Server) ReloadConfig() (err error) {
+	if s.reloadConfig == nil {
+		return errReloadNotSupported
+	}
+	current := s.currentConfig()
+	file := current.File
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("turbo: failed to reload config file %s, error: %v", file, e)
+		}
+	}()
+	c := newConfig(current.rpcType, file, current.Source)
+	c.loadServiceConfig()
+	s.addRoutes(c)
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	// a pending reload is replaced with the newer config
+	select {
+	case <-s.reloadConfig:
+	default:
+	}
+	s.reloadConfig <- c
+	return nil
+}
+
+// ReloadHook is called after a reload is attempted, err is nil if the new config is applied.
+type ReloadHook func(s Servable, err error)
+
+// OnReload registers a ReloadHook
+func (s *Server) OnReload(hook ReloadHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloadHooks = append(s.reloadHooks, hook)
+}
+
 func (s *Server) initChans() {
-	s.reloadConfig = make(chan bool)
+	s.reloadConfig = make(chan *Config, 1)
 	s.exit = make(chan os.Signal, 1)
+	s.upgrade = make(chan os.Signal, 1)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 }
 
-func startHTTPServer(s Servable) *http.Server {
-	s.ServerField().Components = s.ServerField().loadComponents()
-	hs := &http.Server{
-		Addr:    ":" + strconv.FormatInt(s.ServerField().Config.HTTPPort(), 10),
-		Handler: router(s),
+// Context returns a context which is cancelled when the server begins to stop,
+// e.g. on receiving SIGINT or SIGTERM.
+func (s *Server) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
 	}
+	return s.ctx
+}
+
+func (s *Server) currentConfig() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Config
+}
+
+func (s *Server) currentComponents() *Components {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Components
+}
+
+func (s *Server) currentRouter() *mux.Router {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.router
+}
+
+// swap replaces config, components and router all at once
+func (s *Server) swap(c *Config, components *Components, r *mux.Router) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Config = c
+	s.Components = components
+	s.router = r
+}
+
+// swapIfCurrent replaces components and router built from c, unless c has been replaced by a reload meanwhile
+func (s *Server) swapIfCurrent(c *Config, components *Components, r *mux.Router) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Config == c {
+		s.Components = components
+		s.router = r
+	}
+}
+
+func (s *Server) currentHTTPServer() *http.Server {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.httpServer
+}
+
+// serveHTTP dispatches requests to the current router, which may be replaced on reloading config
+func (s *Server) serveHTTP(resp http.ResponseWriter, req *http.Request) {
+	if !s.acquire(resp) {
+		return
+	}
+	defer s.release()
+	s.currentRouter().ServeHTTP(resp, req)
+}
+
+func startHTTPServer(s Servable) {
+	sf := s.ServerField()
+	Handler(s)
+	c := sf.currentConfig()
+	lis, err := listen(c.HTTPAddress(), c.UnixSocketMode())
+	logPanicIf(err)
+	hs := sf.newHTTPServer(c, "")
+	sf.mu.Lock()
+	sf.httpServer = hs
+	sf.httpAddr = lis.Addr()
+	sf.mu.Unlock()
 	go func() {
-		if err := hs.ListenAndServe(); err != nil {
+		if err := hs.Serve(lis); err != nil && err != http.ErrServerClosed {
 			log.Printf("HTTP Server failed to serve: %v", err)
 		}
 	}()
-	log.Info("HTTP Server started")
-	return hs
+	log.Info("HTTP Server started at ", lis.Addr())
 }
 
-func (s *Server) loadComponentsNoPanic() *Components {
+// rebindHTTPServer serves HTTP on lis with a new http.Server, and shuts down the old one gracefully,
+// requests in flight on the old server are not dropped.
+func (s *Server) rebindHTTPServer(lis net.Listener, shutdownTimeout time.Duration) {
+	hs := s.newHTTPServer(s.currentConfig(), "")
+	s.mu.Lock()
+	old := s.httpServer
+	s.httpServer = hs
+	s.mu.Unlock()
+	go func() {
+		if err := hs.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP Server failed to serve: %v", err)
+		}
+	}()
+	s.mu.Lock()
+	s.httpAddr = lis.Addr()
+	s.mu.Unlock()
+	log.Info("HTTP Server moved to ", lis.Addr())
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		logErrorIf(old.Shutdown(ctx))
+	}()
+}
+
+// reload validates the new config, and builds new components and router with it,
+// then swaps them all at once, nothing is changed if any error occurs.
+// If "http_port" or "http_address" is changed, HTTP server is moved to the new address,
+// and rpc services and clients are moved to new addresses, if any.
+func reload(s Servable, c *Config) error {
+	sf := s.ServerField()
+	current := sf.currentConfig()
+	if err := validateMethodNames(s, current, c); err != nil {
+		return err
+	}
+	components, err := sf.loadComponentsNoPanic(c)
+	if err != nil {
+		return err
+	}
+	r, err := router(s, c)
+	if err != nil {
+		return err
+	}
+	var lis net.Listener
+	if c.HTTPAddress() != current.HTTPAddress() && sf.currentHTTPServer() != nil {
+		lis, err = listen(c.HTTPAddress(), c.UnixSocketMode())
+		if err != nil {
+			return err
+		}
+	}
+	if rs, ok := s.(rpcServer); ok {
+		if err := rs.reloadEndpoints(current, c); err != nil {
+			if lis != nil {
+				lis.Close()
+			}
+			return err
+		}
+	}
+	sf.swap(c, components, r)
+	// mappings may be changed, cached responses are out of date
+	sf.InvalidateCache("")
+	if lis != nil {
+		sf.rebindHTTPServer(lis, c.ShutdownTimeout())
+	}
+	if sf.manageLogger {
+		reloadLogLevel(c)
+	}
+	return nil
+}
+
+// validateMethodNames checks that methods newly mapped in urlmapping exist in the backend service client
+func validateMethodNames(s Servable, current, c *Config) error {
+	mapped := make(map[string]bool)
+	for _, m := range current.mappings[urlServiceMaps] {
+		mapped[m[2]] = true
+	}
+	var service interface{}
+	if rs, ok := s.(rpcServer); ok {
+		service = rs.client()
+	} else {
+		service = s.Service()
+	}
+	if service == nil {
+		// no backend client to check, e.g. a service-only server, or one without WithClient()
+		return nil
+	}
+	client := reflect.ValueOf(service)
+	for _, m := range c.mappings[urlServiceMaps] {
+		if mapped[m[2]] {
+			continue
+		}
+		if !client.MethodByName(m[2]).IsValid() {
+			return fmt.Errorf("turbo: no such method [%s] in service, urlmapping: %s %s %s", m[2], m[0], m[1], m[2])
+		}
+	}
+	return nil
+}
+
+func (s *Server) loadComponentsNoPanic(config *Config) (c *Components, err error) {
 	defer func() {
-		if err := recover(); err != nil {
-			log.Error("reload Components failed, err=", err)
+		if e := recover(); e != nil {
+			err = fmt.Errorf("turbo: failed to load components, error: %v", e)
 		}
 	}()
-	return s.loadComponents()
+	return s.loadComponents(config), nil
 }
 
-func (s *Server) loadComponents() *Components {
-	c := &Components{routers: make(map[int]*mux.Router), registeredComponents: s.Components.registeredComponents}
-	for _, m := range s.Config.mappings[interceptors] {
-		names := strings.Split(m[2], ",")
-		components := make([]Interceptor, 0)
-		for _, name := range names {
-			components = append(components, getComponentByName(s, name).(Interceptor))
+func (s *Server) loadComponents(config *Config) *Components {
+	c := &Components{routers: make(map[int]*mux.Router), registeredComponents: s.currentComponents().registeredComponents,
+		builtinComponents: authComponents(config)}
+	common := make([]namedInterceptor, 0)
+	for _, name := range config.GlobalInterceptors() {
+		common = append(common, namedInterceptor{name, getComponentByName(c, name, kindInterceptor, kindMiddleware).(Interceptor)})
+	}
+	c.setCommonInterceptor(common)
+	for _, m := range config.mappings[interceptors] {
+		list := make([]namedInterceptor, 0)
+		excludes := make([]string, 0)
+		for _, name := range strings.Split(m[2], ",") {
+			if strings.HasPrefix(name, "!") {
+				excludes = append(excludes, name[1:])
+				continue
+			}
+			list = append(list, namedInterceptor{name, getComponentByName(c, name, kindInterceptor, kindMiddleware).(Interceptor)})
 		}
-		c.Intercept(strings.Split(m[0], ","), m[1], components...)
+		c.intercept(strings.Split(m[0], ","), m[1], list, excludes)
 		log.Info("interceptor:", m)
 	}
-	for _, m := range s.Config.mappings[preprocessors] {
-		c.SetPreprocessor(strings.Split(m[0], ","), m[1], getComponentByName(s, m[2]).(Preprocessor))
+	for name, priority := range config.InterceptorPriorities() {
+		c.setInterceptorPriority(name, priority)
+	}
+	for _, m := range config.mappings[preprocessors] {
+		list := make([]Preprocessor, 0)
+		for _, name := range strings.Split(m[2], ",") {
+			list = append(list, getComponentByName(c, name, kindPreprocessor).(Preprocessor))
+		}
+		c.SetPreprocessors(strings.Split(m[0], ","), m[1], list...)
 		log.Info("preprocessor:", m)
 	}
-	for _, m := range s.Config.mappings[postprocessors] {
-		c.SetPostprocessor(strings.Split(m[0], ","), m[1], getComponentByName(s, m[2]).(Postprocessor))
+	for _, m := range config.mappings[postprocessors] {
+		transformers, post := postprocessorChain(c, m[2])
+		c.SetResponseTransformers(strings.Split(m[0], ","), m[1], transformers...)
+		if post != nil {
+			c.SetPostprocessor(strings.Split(m[0], ","), m[1], post)
+		}
 		log.Info("postprocessor:", m)
 	}
-	for _, m := range s.Config.mappings[hijackers] {
-		c.SetHijacker(strings.Split(m[0], ","), m[1], getComponentByName(s, m[2]).(Hijacker))
+	for _, m := range config.mappings[hijackers] {
+		c.SetHijacker(strings.Split(m[0], ","), m[1], getComponentByName(c, m[2], kindHijacker).(Hijacker))
 		log.Info("hijacker:", m)
 	}
-	for _, m := range s.Config.mappings[convertors] {
-		c.SetConvertor(m[0], getComponentByName(s, m[1]).(Convertor))
+	for _, m := range config.mappings[convertors] {
+		c.SetConvertor(m[0], getComponentByName(c, m[1], kindConvertor).(Convertor))
 		log.Info("convertor:", m)
 	}
-	if len(s.Config.ErrorHandler()) > 0 {
-		c.WithErrorHandler(getComponentByName(s, s.Config.ErrorHandler()).(ErrorHandlerFunc))
-		log.Info("errorhandler:", s.Config.ErrorHandler())
+	for _, r := range config.authz {
+		var policy AuthzPolicy
+		if len(r.Policy) > 0 {
+			policy = getComponentByName(c, r.Policy, kindPolicy).(AuthzPolicy)
+		}
+		c.AddAuthzRule(r, policy)
+		log.Info("authorization:", r.Path+r.RPC)
+	}
+	if len(config.ErrorHandler()) > 0 {
+		c.WithErrorHandler(getComponentByName(c, config.ErrorHandler(), kindErrorHandler).(ErrorHandlerFunc))
+		log.Info("errorhandler:", config.ErrorHandler())
 	}
 	return c
 }
 
-func getComponentByName(s *Server, name string) interface{} {
-	com, err := s.Component(name)
-	if err != nil {
-		panic(err)
+// postprocessorChain splits names separated by ',' into ResponseTransformers, and an optional Postprocessor,
+// which writes the response, so it must be the last one.
+func postprocessorChain(c *Components, names string) ([]ResponseTransformer, Postprocessor) {
+	list := strings.Split(names, ",")
+	transformers := make([]ResponseTransformer, 0)
+	var post Postprocessor
+	for i, name := range list {
+		switch com := getComponentByName(c, name, kindTransformer, kindPostprocessor).(type) {
+		case ResponseTransformer:
+			transformers = append(transformers, com)
+		case Postprocessor:
+			if i != len(list)-1 {
+				panic(fmt.Errorf("postprocessor [%s] writes the response, it must be the last one in [%s]", name, names))
+			}
+			post = com
+		}
+	}
+	return transformers, post
+}
+
+// getComponentByName returns the component registered with name, or the built-in component with name,
+// it panics if it's not found, or it's not of any of kinds.
+func getComponentByName(c *Components, name string, kinds ...string) interface{} {
+	com := c.registeredComponents[name]
+	if com == nil {
+		com = c.builtinComponents[name]
+	}
+	if com == nil {
+		panic(errors.New("no such component: " + name + ", forget to register?"))
+	}
+	if k := componentKind(com); !contains(kinds, k) {
+		panic(fmt.Errorf("component [%s] is registered as [%s], can not be used as [%s]", name, k, strings.Join(kinds, "] or [")))
 	}
 	return com
 }
 
-func waitForQuit(s Servable, httpServer *http.Server, grpcServer *grpc.Server, thriftServer *thrift.TSimpleServer) {
+func waitForQuit(s Servable, httpServing bool) {
 	signal.Notify(s.ServerField().exit, os.Interrupt, os.Kill, syscall.SIGTERM, syscall.SIGQUIT)
-	if httpServer == nil {
-		waitOnExit(s, httpServer, grpcServer, thriftServer)
+	signal.Notify(s.ServerField().upgrade, syscall.SIGUSR2)
+	if !httpServing {
+		// waitOnExit() never reads reloadConfig, ReloadConfig() returns errReloadNotSupported instead
+		s.ServerField().reloadConfig = nil
+	}
+	if s.ServerField().adminServer == nil {
+		s.ServerField().adminServer = startAdminServer(s)
+	}
+	s.ServerField().setReady(true)
+	logErrorIf(notifyReady())
+	if httpServing {
+		waitOnExitAndReload(s)
 	} else {
-		waitOnExitAndReload(s, httpServer, grpcServer, thriftServer)
+		waitOnExit(s)
 	}
 }
 
-func waitOnExit(s Servable, httpServer *http.Server, grpcServer *grpc.Server, thriftServer *thrift.TSimpleServer) {
+func waitOnExit(s Servable) {
+Wait:
 	select {
-	case <-s.ServerField().exit:
-		log.Info("Received CTRL-C, Service is stopping...")
+	case sig := <-s.ServerField().exit:
+		log.Infof("Received %s, Service is stopping...", sig)
+	case <-s.ServerField().upgrade:
+		if !gracefulRestart(s) {
+			goto Wait
+		}
 	}
-	quit(s, httpServer, grpcServer, thriftServer)
+	quit(s)
 }
 
-func waitOnExitAndReload(s Servable, httpServer *http.Server, grpcServer *grpc.Server, thriftServer *thrift.TSimpleServer) {
+func waitOnExitAndReload(s Servable) {
 Wait:
 	select {
-	case <-s.ServerField().exit:
-		log.Info("Received CTRL-C, Service is stopping...")
-	case <-s.ServerField().reloadConfig:
-		if httpServer == nil {
+	case sig := <-s.ServerField().exit:
+		log.Infof("Received %s, Service is stopping...", sig)
+	case <-s.ServerField().upgrade:
+		if !gracefulRestart(s) {
 			goto Wait
 		}
-		log.Info("Reloading configuration...")
-		newComponents := s.ServerField().loadComponentsNoPanic()
-		newRouter := router(s)
-		httpServer.Handler = newRouter
-		s.ServerField().Components = newComponents
-		log.Info("Configuration reloaded")
+	case c := <-s.ServerField().reloadConfig:
+		applyReload(s, c)
 		goto Wait
 	}
-	quit(s, httpServer, grpcServer, thriftServer)
+	quit(s)
 }
 
-func quit(s Servable, httpServer *http.Server, grpcServer *grpc.Server, thriftServer *thrift.TSimpleServer) {
-	if httpServer != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-		defer cancel()
-		httpServer.Shutdown(ctx)
+// gracefulRestart hands listening sockets to a new process, it returns true if the new process is ready,
+// then this process should be shut down, requests in flight are not dropped.
+func gracefulRestart(s Servable) bool {
+	log.Info("Received SIGUSR2, restarting gracefully...")
+	if err := upgrade(s.ServerField().currentConfig().UpgradeTimeout()); err != nil {
+		log.Error("Graceful restart failed, keep serving, error: ", err)
+		return false
+	}
+	log.Info("New process is ready, Service is stopping...")
+	return true
+}
+
+// reloadLoop applies configs from ReloadConfig() until the server begins to stop,
+// it's used by servers which don't wait for signals, see Start() and Handler().
+func reloadLoop(s Servable) {
+	sf := s.ServerField()
+	for {
+		select {
+		case <-sf.Context().Done():
+			return
+		case c := <-sf.reloadConfig:
+			applyReload(s, c)
+		}
+	}
+}
+
+func applyReload(s Servable, c *Config) {
+	log.Info("Reloading configuration...")
+	err := reloadNoPanic(s, c)
+	if err != nil {
+		log.Error("Configuration not reloaded, keep using the current one, error: ", err)
+	} else {
+		log.Info("Configuration reloaded")
+	}
+	s.ServerField().runReloadHooks(s, err)
+}
+
+// reloadNoPanic calls reload(), a panic in it is returned as an error, so that a bad reload doesn't stop the process
+func reloadNoPanic(s Servable, c *Config) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("turbo: failed to reload config, error: %v", e)
+		}
+	}()
+	return reload(s, c)
+}
+
+func (s *Server) runReloadHooks(servable Servable, err error) {
+	s.mu.RLock()
+	hooks := s.reloadHooks
+	s.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(servable, err)
+	}
+}
+
+func quit(s Servable) {
+	c := s.ServerField().currentConfig()
+	ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownDrainPeriod()+c.ShutdownTimeout())
+	defer cancel()
+	shutdown(ctx, s)
+}
+
+// shutdown stops s gracefully, it's done only once, later calls return the result of the first one.
+// It returns the error of shutting down HTTP server, e.g. ctx is done before requests in flight complete.
+func shutdown(ctx context.Context, s Servable) error {
+	sf := s.ServerField()
+	sf.shutdownOnce.Do(func() {
+		sf.shutdownErr = stop(ctx, s)
+	})
+	return sf.shutdownErr
+}
+
+func stop(ctx context.Context, s Servable) (err error) {
+	sf := s.ServerField()
+	sf.setReady(false)
+	if sf.cancel != nil {
+		sf.cancel()
+	}
+	rs, isRPCServer := s.(rpcServer)
+	if isRPCServer {
+		rs.startDraining()
+	}
+	if period := sf.currentConfig().ShutdownDrainPeriod(); period > 0 {
+		log.Infof("Draining for %s...", period)
+		select {
+		case <-time.After(period):
+		case <-ctx.Done():
+		}
+	}
+	if httpServer := sf.currentHTTPServer(); httpServer != nil {
+		if err = httpServer.Shutdown(ctx); err != nil {
+			log.Errorf("Http Server failed to shutdown gracefully: %v", err)
+		}
 		log.Info("Http Server stopped")
 	}
-	if grpcServer != nil {
-		s.(*GrpcServer).gClient.close()
-		grpcServer.GracefulStop()
-		log.Info("Grpc Server stopped")
+	if isRPCServer {
+		logErrorIf(rs.closeClient())
+		rs.stopService(ctx)
+	}
+	if adminServer := sf.adminServer; adminServer != nil {
+		adminServer.Close()
+		sf.adminServer = nil
+		log.Info("Admin Server stopped")
+	}
+	if sf.Initializer != nil {
+		sf.Initializer.StopService(s)
 	}
-	if thriftServer != nil {
-		s.(*ThriftServer).tClient.close()
-		thriftServer.Stop()
-		log.Info("Grpc Server stopped")
+	return err
+}
+
+// stopGrpcServer stops grpcServer gracefully, in-flight RPCs are cancelled if they don't complete before ctx is done
+func stopGrpcServer(ctx context.Context, grpcServer *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Warn("Grpc Server shutdown timeout, cancelling in-flight RPCs")
+		grpcServer.Stop()
 	}
-	s.ServerField().Initializer.StopService(s)
 }
 
 // Initializable defines funcs run before service started and after service stopped
@@ -0,0 +1,216 @@
+package turbo
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testServiceClient struct{}
+
+func (c *testServiceClient) SayHello() {}
+
+func (c *testServiceClient) EatApple() {}
+
+func newTestReloadServer() *GrpcServer {
+	s := &GrpcServer{
+		Server: &Server{
+			Config:     NewConfig("grpc", "test/service_test.yaml"),
+			Components: new(Components),
+		},
+		gClient: &grpcClient{grpcService: &testServiceClient{}},
+	}
+	s.RegisterComponent("LogInterceptor", &BaseInterceptor{})
+	s.RegisterComponent("preprocessor", Preprocessor(func(http.ResponseWriter, *http.Request) error { return nil }))
+	s.RegisterComponent("postprocessor", Postprocessor(func(http.ResponseWriter, *http.Request, interface{}, error) {}))
+	s.RegisterComponent("hijacker", Hijacker(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte("hijacked"))
+	}))
+	s.RegisterComponent("convertor", Convertor(func(*http.Request) reflect.Value { return reflect.Value{} }))
+	s.RegisterComponent("error_handler", ErrorHandlerFunc(func(http.ResponseWriter, *http.Request, error) {}))
+	s.Components = s.loadComponents(s.Config)
+	r, err := router(s, s.Config)
+	if err != nil {
+		panic(err)
+	}
+	s.swap(s.Config, s.Components, r)
+	return s
+}
+
+func TestReload(t *testing.T) {
+	s := newTestReloadServer()
+	var hookErr error
+	s.OnReload(func(servable Servable, err error) { hookErr = err })
+
+	c := NewConfig("grpc", "test/service_test.yaml")
+	c.mappings[urlServiceMaps] = append(c.mappings[urlServiceMaps], [3]string{"GET", "/apple", "EatApple"})
+	err := reload(s, c)
+	s.runReloadHooks(s, err)
+	assert.Nil(t, hookErr)
+	assert.Equal(t, c, s.currentConfig())
+
+	s.switcher = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		return methodName, nil
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/apple", nil)
+	s.serveHTTP(w, req)
+	assert.Equal(t, `"EatApple"`, w.Body.String())
+}
+
+func TestReloadKeepsCurrentOnError(t *testing.T) {
+	s := newTestReloadServer()
+	current := s.currentConfig()
+	components := s.currentComponents()
+
+	c := NewConfig("grpc", "test/service_test.yaml")
+	c.mappings[urlServiceMaps] = append(c.mappings[urlServiceMaps], [3]string{"GET", "/apple", "EatBanana"})
+	assert.Equal(t, errors.New("turbo: no such method [EatBanana] in service, urlmapping: GET /apple EatBanana"), reload(s, c))
+
+	c = NewConfig("grpc", "test/service_test.yaml")
+	c.mappings[hijackers] = [][3]string{{"GET", "/hello", "not_registered"}}
+	assert.Equal(t, errors.New("turbo: failed to load components, error: no such component: not_registered, forget to register?"), reload(s, c))
+
+	c = NewConfig("grpc", "test/service_test.yaml")
+	c.mappings[urlServiceMaps] = append(c.mappings[urlServiceMaps], [3]string{"GET", "/apple/{id:[0-9+}", "EatApple"})
+	assert.NotNil(t, reload(s, c))
+
+	assert.Equal(t, current, s.currentConfig())
+	assert.Equal(t, components, s.currentComponents())
+}
+
+func TestReloadWithoutClient(t *testing.T) {
+	s := newTestReloadServer()
+	s.gClient = new(grpcClient)
+	c := NewConfig("grpc", "test/service_test.yaml")
+	assert.Nil(t, reloadNoPanic(s, c))
+	assert.Equal(t, c, s.currentConfig())
+
+	s.Components = nil
+	assert.NotNil(t, reloadNoPanic(s, NewConfig("grpc", "test/service_test.yaml")))
+	assert.Equal(t, c, s.currentConfig())
+}
+
+func TestReloadConfigCoalesces(t *testing.T) {
+	s := &Server{Config: NewConfig("grpc", "test/service_test.yaml")}
+	assert.Equal(t, errReloadNotSupported, s.ReloadConfig())
+	s.initChans()
+	assert.Nil(t, s.ReloadConfig())
+	assert.Nil(t, s.ReloadConfig())
+	assert.Equal(t, 1, len(s.reloadConfig))
+
+	s.Config.File = "test/no_such_file.yaml"
+	assert.NotNil(t, s.ReloadConfig())
+}
+
+func TestReloadRebindsHTTPServer(t *testing.T) {
+	s := newTestReloadServer()
+	oldLis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s.httpServer = &http.Server{Handler: http.HandlerFunc(s.serveHTTP)}
+	go s.httpServer.Serve(oldLis)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+	c := NewConfig("grpc", "test/service_test.yaml")
+	c.configs[httpPort] = strconv.Itoa(port)
+	assert.Nil(t, reload(s, c))
+	defer s.currentHTTPServer().Close()
+
+	resp, err := http.Get("http://127.0.0.1:" + strconv.Itoa(port) + "/hello")
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "hijacked", string(body))
+
+	closed := false
+	for deadline := time.Now().Add(time.Second); !closed && time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		_, err := http.Get("http://" + oldLis.Addr().String() + "/hello")
+		closed = err != nil
+	}
+	assert.True(t, closed, "the old listener should be closed")
+}
+
+func TestRouteTimeout(t *testing.T) {
+	var deadline time.Time
+	h := withTimeout(func(resp http.ResponseWriter, req *http.Request) {
+		deadline, _ = req.Context().Deadline()
+	}, time.Second*3)
+	req, _ := http.NewRequest("GET", "/hello", nil)
+	h(httptest.NewRecorder(), req)
+	assert.WithinDuration(t, time.Now().Add(time.Second*3), deadline, time.Second)
+}
+
+func TestRegisterComponent(t *testing.T) {
+	s := &Server{Components: new(Components)}
+	s.RegisterInterceptor("interceptor", &BaseInterceptor{})
+	s.RegisterPreprocessor("preprocessor", func(http.ResponseWriter, *http.Request) error { return nil })
+	s.RegisterPostprocessor("postprocessor", func(http.ResponseWriter, *http.Request, interface{}, error) {})
+	s.RegisterHijacker("hijacker", func(http.ResponseWriter, *http.Request) {})
+	s.RegisterConvertor("convertor", func(*http.Request) reflect.Value { return reflect.Value{} })
+	s.RegisterErrorHandler("error_handler", func(http.ResponseWriter, *http.Request, error) {})
+	// funcs with the signature of a component are converted to that component
+	s.RegisterComponent("raw_preprocessor", func(http.ResponseWriter, *http.Request) error { return nil })
+	for name, kind := range map[string]string{"interceptor": kindInterceptor, "preprocessor": kindPreprocessor,
+		"postprocessor": kindPostprocessor, "hijacker": kindHijacker, "convertor": kindConvertor,
+		"error_handler": kindErrorHandler, "raw_preprocessor": kindPreprocessor} {
+		c, err := s.Component(name)
+		assert.Nil(t, err)
+		assert.Equal(t, kind, componentKind(c), name)
+	}
+
+	defer func() {
+		assert.Equal(t, errors.New("turbo: failed to register component [name], error: string is not an Interceptor, "+
+			"Preprocessor, Postprocessor, ResponseTransformer, Hijacker, Convertor, ErrorHandlerFunc, AuthzPolicy, Middleware or ThriftInterceptor"), recover())
+	}()
+	s.RegisterComponent("name", "not a component")
+}
+
+func TestLoadComponentsWrongKind(t *testing.T) {
+	s := &Server{Config: NewConfigFromMap("grpc", map[string]string{httpPort: "8081"}), Components: new(Components)}
+	s.RegisterHijacker("hijacker", func(http.ResponseWriter, *http.Request) {})
+	assert.Nil(t, s.AddRoute(Route{Method: "GET", Path: "/hello", RPC: "SayHello", Interceptors: []string{"hijacker"}}))
+	_, err := s.loadComponentsNoPanic(s.Config)
+	assert.Equal(t, errors.New("turbo: failed to load components, error: component [hijacker] is registered as [hijacker], "+
+		"can not be used as [interceptor] or [middleware]"), err)
+}
+
+func TestAddRoute(t *testing.T) {
+	s := &GrpcServer{
+		Server:  &Server{Config: NewConfigFromMap("grpc", map[string]string{httpPort: "8081"}), Components: new(Components)},
+		gClient: &grpcClient{grpcService: &testServiceClient{}},
+	}
+	s.initChans()
+	s.RegisterHijacker("hijacker", func(resp http.ResponseWriter, req *http.Request) { resp.Write([]byte("hijacked")) })
+	assert.Nil(t, s.AddRoute(Route{Method: "GET", Path: "/hello", RPC: "SayHello", Hijacker: "hijacker"}))
+	assert.Equal(t, errors.New("turbo: invalid route GET hello, [path] should start with '/', got: hello"),
+		s.AddRoute(Route{Method: "GET", Path: "hello", RPC: "SayHello"}))
+	r, err := router(s, s.Config)
+	assert.Nil(t, err)
+	s.swap(s.Config, s.loadComponents(s.Config), r)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/hello", nil)
+	s.serveHTTP(w, req)
+	assert.Equal(t, "hijacked", w.Body.String())
+
+	// routes added in code are kept on reloading
+	assert.Nil(t, s.ReloadConfig())
+	c := <-s.reloadConfig
+	assert.Equal(t, [][3]string{{"GET", "/hello", "SayHello"}}, c.mappings[urlServiceMaps])
+	assert.Equal(t, [][3]string{{"GET", "/hello", "hijacker"}}, c.mappings[hijackers])
+}
+
+func setupServerWithConfig(t *testing.T, config string) (*Server, func()) {
+	file, remove := writeTestConfig(t, config)
+	return &Server{Config: NewConfig("grpc", file), Components: new(Components)}, remove
+}
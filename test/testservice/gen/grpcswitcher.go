@@ -20,7 +20,9 @@ var GrpcSwitcher = func(s turbo.Servable, methodName string, resp http.ResponseW
 		if err != nil {
 			return nil, err
 		}
-		rpcResponse, err = s.Service().(g.TestServiceClient).SayHello(req.Context(), request, callOptions...)
+		rpcResponse, err = turbo.Coalesce(req, methodName, request, func() (interface{}, error) {
+			return s.Service().(g.TestServiceClient).SayHello(req.Context(), request, callOptions...)
+		})
 	default:
 		return nil, errors.New("No such method[" + methodName + "]")
 	}
@@ -20,7 +20,8 @@ var ThriftSwitcher = func(s turbo.Servable, methodName string, resp http.Respons
 		if err != nil {
 			return nil, err
 		}
-		return s.Service().(*gen.TestServiceClient).SayHello(
+		return turbo.Coalesce(req, methodName, params, func() (interface{}, error) {
+			return s.Service().(*gen.TestServiceClient).SayHello(
 			params[0].Interface().(*gen.CommonValues),
 			params[1].Interface().(string),
 			params[2].Interface().(int64),
@@ -33,14 +34,17 @@ var ThriftSwitcher = func(s turbo.Servable, methodName string, resp http.Respons
 			params[9].Interface().([]int32),
 			params[10].Interface().([]bool),
 			params[11].Interface().([]float64), )
+		})
 
 	case "TestJson":
 		params, err := turbo.BuildThriftRequest(s, gen.TestServiceTestJsonArgs{}, req, buildStructArg)
 		if err != nil {
 			return nil, err
 		}
-		return s.Service().(*gen.TestServiceClient).TestJson(
+		return turbo.Coalesce(req, methodName, params, func() (interface{}, error) {
+			return s.Service().(*gen.TestServiceClient).TestJson(
 			params[0].Interface().(*gen.TestJsonRequest), )
+		})
 
 	default:
 		return nil, errors.New("No such method[" + methodName + "]")
@@ -1,10 +1,16 @@
 package turbo
 
 import (
+	"context"
 	"git.apache.org/thrift.git/lib/go/thrift"
+	"sync"
+	"time"
 )
 
 type thriftClient struct {
+	mu            sync.RWMutex
+	addr          string
+	clientCreator func(trans thrift.TTransport, f thrift.TProtocolFactory) interface{}
 	thriftService interface{}
 	transport     thrift.TTransport
 	factory       thrift.TProtocolFactory
@@ -15,26 +21,96 @@ func (t *thriftClient) init(addr string, clientCreator func(trans thrift.TTransp
 		return
 	}
 	log.Debugf("connecting thrift addr: %s", addr)
-	t.connect(addr)
+	t.addr = addr
+	t.clientCreator = clientCreator
+	var err error
+	t.transport, err = connect(addr)
+	logPanicIf(err)
+	t.factory = thrift.NewTBinaryProtocolFactoryDefault()
 	t.thriftService = clientCreator(t.transport, t.factory)
 }
 
-func (t *thriftClient) connect(hostPort string) {
-	tSocket, err := thrift.NewTSocket(hostPort)
-	logPanicIf(err)
-
-	t.transport, err = thrift.NewTTransportFactory().GetTransport(tSocket)
-	logPanicIf(err)
+// connect connects to addr, which is like "host:port", or a unix domain socket address like "unix:///var/run/service.sock"
+func connect(addr string) (thrift.TTransport, error) {
+	var tSocket *thrift.TSocket
+	if isUnixAddr(addr) {
+		conn, err := dial(addr, 0)
+		if err != nil {
+			return nil, err
+		}
+		tSocket = thrift.NewTSocketFromConnTimeout(conn, 0)
+	} else {
+		var err error
+		if tSocket, err = thrift.NewTSocket(addr); err != nil {
+			return nil, err
+		}
+	}
+	transport, err := thrift.NewTTransportFactory().GetTransport(tSocket)
+	if err != nil {
+		return nil, err
+	}
+	if !transport.IsOpen() {
+		if err = transport.Open(); err != nil {
+			return nil, err
+		}
+	}
+	return transport, nil
+}
 
-	err = t.transport.Open()
-	logPanicIf(err)
+func (t *thriftClient) service() interface{} {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.thriftService
+}
 
-	t.factory = thrift.NewTBinaryProtocolFactoryDefault()
+// reconnect connects to addr if it's changed, and returns a function to switch the client to the new connection,
+// the old transport is closed after closeDelay by it, to let in-flight calls on it complete.
+// The client is left as it is until the returned function is called.
+func (t *thriftClient) reconnect(addr string, closeDelay time.Duration) (func(), error) {
+	t.mu.RLock()
+	current, currentAddr := t.transport, t.addr
+	t.mu.RUnlock()
+	if current == nil || addr == currentAddr {
+		return func() {}, nil
+	}
+	log.Debugf("reconnecting thrift addr: %s", addr)
+	transport, err := connect(addr)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		t.mu.Lock()
+		old := t.transport
+		t.addr, t.transport = addr, transport
+		t.thriftService = t.clientCreator(transport, t.factory)
+		t.mu.Unlock()
+		time.AfterFunc(closeDelay, func() { old.Close() })
+	}, nil
 }
 
 func (t *thriftClient) close() error {
-	if t.transport == nil {
+	t.mu.RLock()
+	transport := t.transport
+	t.mu.RUnlock()
+	if transport == nil {
 		return nil
 	}
-	return t.transport.Close()
+	return transport.Close()
+}
+
+// ping opens a new connection to the thrift service, and closes it immediately,
+// addr can be a unix domain socket address, as in connect().
+func (t *thriftClient) ping(ctx context.Context) error {
+	timeout := time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = deadline.Sub(time.Now())
+	}
+	t.mu.RLock()
+	addr := t.addr
+	t.mu.RUnlock()
+	conn, err := dial(addr, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
 }
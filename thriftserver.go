@@ -1,33 +1,44 @@
 package turbo
 
 import (
+	"context"
+	"errors"
 	"git.apache.org/thrift.git/lib/go/thrift"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
 type ThriftServer struct {
+	// inFlight is the number of thrift calls being processed,
+	// it comes first to be 64-bit aligned for atomic operations
+	inFlight int64
 	*Server
 	tClient      *thriftClient
-	httpServer   *http.Server
-	thriftServer *thrift.TSimpleServer
+	thriftServer *thriftService
+	processor    thrift.TProcessor
+	// clientCreator creates the client of backend service, it's set by WithClient()
+	clientCreator thriftClientCreator
 }
 
 func NewThriftServer(initializer Initializable, configFilePath string) *ThriftServer {
+	return NewThriftServerWithConfig(initializer, NewConfig("thrift", configFilePath))
+}
+
+// NewThriftServerWithConfig returns a ThriftServer with a loaded config, e.g. a config from NewConfigFromSource()
+func NewThriftServerWithConfig(initializer Initializable, c *Config) *ThriftServer {
 	if initializer == nil {
 		initializer = &defaultInitializer{}
 	}
 	s := &ThriftServer{
 		Server: &Server{
-			Config:       NewConfig("thrift", configFilePath),
-			Components:   new(Components),
-			reloadConfig: make(chan bool),
-			Initializer:  initializer,
+			Config:      c,
+			Components:  new(Components),
+			Initializer: initializer,
 		},
 		tClient: new(thriftClient),
 	}
 	s.initChans()
-	initLogger(s.Config)
 	return s
 }
 
@@ -36,64 +47,212 @@ type thriftClientCreator func(trans thrift.TTransport, f thrift.TProtocolFactory
 // StartTHRIFT starts both HTTP server and Thrift service
 func (s *ThriftServer) StartTHRIFT(clientCreator thriftClientCreator, sw switcher,
 	registerTProcessor func() thrift.TProcessor) {
+	s.setupLogger()
 	log.Info("Starting Turbo...")
 	s.Initializer.InitService(s)
 	s.thriftServer = s.startThriftServiceInternal(registerTProcessor, false)
 	time.Sleep(time.Second * 1)
-	s.httpServer = s.startThriftHTTPServerInternal(clientCreator, sw)
+	s.startThriftHTTPServerInternal(clientCreator, sw)
 	s.watchConfig()
-	waitForQuit(s, s.httpServer, nil, s.thriftServer)
+	waitForQuit(s, true)
 	log.Info("Turbo exit, bye!")
 }
 
 // StartThriftHTTPServer starts a HTTP server which sends requests via Thrift
 func (s *ThriftServer) StartThriftHTTPServer(clientCreator thriftClientCreator, sw switcher) {
+	s.setupLogger()
 	s.Initializer.InitService(s)
-	s.httpServer = s.startThriftHTTPServerInternal(clientCreator, sw)
+	s.startThriftHTTPServerInternal(clientCreator, sw)
 	s.watchConfig()
-	waitForQuit(s, s.httpServer, nil, nil)
+	waitForQuit(s, true)
 	log.Info("Thrift HttpServer exit, bye!")
 }
 
 // StartThriftService starts a Thrift service
 func (s *ThriftServer) StartThriftService(registerTProcessor func() thrift.TProcessor) {
+	s.setupLogger()
 	s.Initializer.InitService(s)
 	s.thriftServer = s.startThriftServiceInternal(registerTProcessor, true)
-	waitForQuit(s, nil, nil, s.thriftServer)
+	waitForQuit(s, false)
 	log.Info("Thrift Service exit, bye!")
 }
 
-func (s *ThriftServer) startThriftHTTPServerInternal(clientCreator thriftClientCreator, sw switcher) *http.Server {
+func (s *ThriftServer) startThriftHTTPServerInternal(clientCreator thriftClientCreator, sw switcher) {
 	log.Info("Starting HTTP Server...")
-	switcherFunc = sw
-	s.tClient.init(s.Config.ThriftServiceHost()+":"+s.Config.ThriftServicePort(), clientCreator)
-	return startHTTPServer(s)
+	s.WithClient(clientCreator, sw)
+	s.connectClient()
+	startHTTPServer(s)
+}
+
+// WithClient sets the creator of the thrift client, and the switcher which calls its methods,
+// they are used by Start() and Handler().
+func (s *ThriftServer) WithClient(clientCreator thriftClientCreator, sw switcher) *ThriftServer {
+	s.clientCreator = clientCreator
+	s.switcher = sw
+	return s
+}
+
+// Start starts the HTTP server and returns, it's shut down when ctx is done, or Shutdown() is called.
+// Unlike StartThriftHTTPServer(), it doesn't block, handle signals, watch the config file, or set up
+// the process-wide logger, so that turbo can be embedded in a program, e.g. with other servers.
+func (s *ThriftServer) Start(ctx context.Context) error {
+	return start(ctx, s)
+}
+
+// Handler returns an http.Handler which serves the routes of s, without starting an HTTP server,
+// see Start() for what's not done by it.
+func (s *ThriftServer) Handler() http.Handler {
+	return embeddedHandler(s)
+}
+
+// Shutdown stops s gracefully, it returns an error if requests in flight don't complete before ctx is done
+func (s *ThriftServer) Shutdown(ctx context.Context) error {
+	return shutdown(ctx, s)
 }
 
-func (s *ThriftServer) startThriftServiceInternal(registerTProcessor func() thrift.TProcessor, alone bool) *thrift.TSimpleServer {
-	port := s.Config.ThriftServicePort()
-	log.Infof("Starting Thrift Service at :%s...", port)
-	transport, err := thrift.NewTServerSocket(":" + port)
+func (s *ThriftServer) connectClient() {
+	if s.clientCreator != nil {
+		s.tClient.init(s.currentConfig().ThriftServiceAddress(), s.clientCreator)
+	}
+}
+
+func (s *ThriftServer) startThriftServiceInternal(registerTProcessor func() thrift.TProcessor, alone bool) *thriftService {
+	c := s.currentConfig()
+	addr := c.thriftServiceListenAddress()
+	log.Infof("Starting Thrift Service at %s...", addr)
+	s.processor = registerTProcessor()
+	interceptors, err := s.thriftInterceptors(c)
 	logPanicIf(err)
-	server := thrift.NewTSimpleServer4(registerTProcessor(), transport,
-		thrift.NewTTransportFactory(), thrift.NewTBinaryProtocolFactoryDefault())
-	go server.Serve()
+	server, err := s.newThriftServer(c, addr, interceptors)
+	logPanicIf(err)
+	go s.serveThrift(server)
 	log.Info("Thrift Service started")
 	return server
 }
 
+// newThriftServer returns a thrift server on addr, which is a unix domain socket address if it begins with "unix://"
+func (s *ThriftServer) newThriftServer(c *Config, addr string, interceptors []ThriftInterceptor) (*thriftService, error) {
+	lis, err := listen(addr, c.UnixSocketMode())
+	if err != nil {
+		return nil, err
+	}
+	return newThriftService(lis, s.processor, s.currentConfig, &s.inFlight, interceptors), nil
+}
+
+func (s *ThriftServer) serveThrift(server *thriftService) {
+	if err := server.Serve(); err != nil {
+		log.Errorf("Thrift Service failed to serve: %v", err)
+	}
+}
+
 // ThriftService returns a Thrift client instance,
 // example: client := turbo.ThriftService().(proto.YourServiceClient)
 func (s *ThriftServer) Service() interface{} {
-	if s == nil || s.tClient == nil || s.tClient.thriftService == nil {
+	if s == nil || s.tClient == nil || s.tClient.service() == nil {
 		log.Panic("thrift connection not initiated!")
 	}
-	return s.tClient.thriftService
+	return s.tClient.service()
 }
 
 func (s *ThriftServer) ServerField() *Server { return s.Server }
 
+func (s *ThriftServer) client() interface{} {
+	if s.tClient == nil {
+		return nil
+	}
+	return s.tClient.service()
+}
+
+func (s *ThriftServer) startDraining() {}
+
+// waitForInFlight waits until all thrift calls being processed complete, or ctx is done
+func (s *ThriftServer) waitForInFlight(ctx context.Context) {
+	ticker := time.NewTicker(time.Millisecond * 10)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&s.inFlight) > 0 {
+		select {
+		case <-ctx.Done():
+			log.Warnf("Thrift Server shutdown timeout, %d calls still in flight", atomic.LoadInt64(&s.inFlight))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ThriftServer) closeClient() error {
+	return s.tClient.close()
+}
+
+// stopService stops accepting new connections, and waits for in-flight calls
+func (s *ThriftServer) stopService(ctx context.Context) {
+	s.mu.RLock()
+	server := s.thriftServer
+	s.mu.RUnlock()
+	if server == nil {
+		return
+	}
+	server.Stop()
+	s.waitForInFlight(ctx)
+	server.closeConnections()
+	log.Info("Thrift Server stopped")
+}
+
+// reloadEndpoints moves the thrift service to the new address, and applies "thrift_interceptor",
+// if it's served in this process, and reconnects the thrift client if the address of thrift service is changed.
+// The new listener and client connection are both opened before any of them is switched to.
+func (s *ThriftServer) reloadEndpoints(current, c *Config) error {
+	if s.thriftServer == nil {
+		switchClient, err := s.tClient.reconnect(c.ThriftServiceAddress(), c.ShutdownTimeout())
+		if err != nil {
+			return err
+		}
+		switchClient()
+		return nil
+	}
+	interceptors, err := s.thriftInterceptors(c)
+	if err != nil {
+		return err
+	}
+	var server *thriftService
+	if c.thriftServiceListenAddress() != current.thriftServiceListenAddress() {
+		if server, err = s.newThriftServer(c, c.thriftServiceListenAddress(), interceptors); err != nil {
+			return err
+		}
+	}
+	// the new listener accepts connections into its backlog before it's served
+	switchClient, err := s.tClient.reconnect(c.ThriftServiceAddress(), c.ShutdownTimeout())
+	if err != nil {
+		if server != nil {
+			server.Stop()
+		}
+		return err
+	}
+	if server != nil {
+		go s.serveThrift(server)
+		s.mu.Lock()
+		old := s.thriftServer
+		s.thriftServer = server
+		s.mu.Unlock()
+		// established connections are kept serving by their own goroutines, until they are closed after "shutdown_timeout"
+		old.Stop()
+		time.AfterFunc(c.ShutdownTimeout(), old.closeConnections)
+		log.Info("Thrift Service moved to ", c.thriftServiceListenAddress())
+	}
+	s.thriftServer.setInterceptors(interceptors)
+	switchClient()
+	return nil
+}
+
+// checkBackend pings the thrift service, it only checks that a new connection can be opened,
+// since thrift has no standard health checking call.
+func (s *ThriftServer) checkBackend(ctx context.Context) error {
+	if s.tClient == nil || s.tClient.service() == nil {
+		return errors.New("thrift client is not connected")
+	}
+	return s.tClient.ping(ctx)
+}
+
 func (s *ThriftServer) Stop() {
 	log.Info("Stop() invoked, Service is stopping...")
-	quit(s, s.httpServer, nil, s.thriftServer)
+	quit(s)
 }
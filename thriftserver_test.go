@@ -0,0 +1,184 @@
+package turbo
+
+import (
+	"context"
+	"errors"
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThriftWaitForInFlight(t *testing.T) {
+	s := &ThriftServer{}
+	atomic.AddInt64(&s.inFlight, 1)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		atomic.AddInt64(&s.inFlight, -1)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.waitForInFlight(ctx)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&s.inFlight))
+	assert.Nil(t, ctx.Err())
+}
+
+func TestThriftWaitForInFlightTimeout(t *testing.T) {
+	s := &ThriftServer{}
+	atomic.AddInt64(&s.inFlight, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	s.waitForInFlight(ctx)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.inFlight))
+	assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+}
+
+type testThriftProtocol struct {
+	thrift.TProtocol
+	skipped  bool
+	messages []thrift.TMessageType
+}
+
+func (p *testThriftProtocol) Skip(thrift.TType) error { p.skipped = true; return nil }
+func (p *testThriftProtocol) ReadMessageEnd() error   { return nil }
+func (p *testThriftProtocol) WriteMessageBegin(name string, typeID thrift.TMessageType, seqID int32) error {
+	p.messages = append(p.messages, typeID)
+	return nil
+}
+func (p *testThriftProtocol) WriteMessageEnd() error { return nil }
+func (p *testThriftProtocol) Flush() error           { return nil }
+
+type testThriftProcessor func(in, out thrift.TProtocol) (bool, thrift.TException)
+
+func (f testThriftProcessor) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	return f(in, out)
+}
+
+type testThriftInterceptor struct {
+	name   string
+	reject error
+	calls  *[]string
+}
+
+func (i *testThriftInterceptor) Before(call *ThriftCall) error {
+	*i.calls = append(*i.calls, i.name+".Before "+call.Method)
+	return i.reject
+}
+
+func (i *testThriftInterceptor) After(call *ThriftCall, err error) error {
+	*i.calls = append(*i.calls, i.name+".After "+call.Method+" "+errString(err))
+	return nil
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
+
+func TestThriftInterceptors(t *testing.T) {
+	calls := make([]string, 0)
+	a := &testThriftInterceptor{name: "a", calls: &calls}
+	b := &testThriftInterceptor{name: "b", calls: &calls}
+	processor := testThriftProcessor(func(in, out thrift.TProtocol) (bool, thrift.TException) {
+		name, typeID, seqID, err := in.ReadMessageBegin()
+		assert.Nil(t, err)
+		calls = append(calls, "process "+name)
+		assert.Equal(t, thrift.CALL, typeID)
+		assert.Equal(t, int32(7), seqID)
+		if name == "Panic" {
+			panic("boom")
+		}
+		return true, nil
+	})
+	var inFlight int64
+	c := NewConfigFromMap("thrift", map[string]string{httpPort: "8081"})
+	service := newThriftService(nil, processor, func() *Config { return c }, &inFlight, []ThriftInterceptor{a, b})
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9090}
+
+	p := &testThriftProtocol{}
+	assert.True(t, service.serveCall(&ThriftCall{Method: "SayHello", SeqID: 7, Remote: remote}, thrift.CALL, p, p))
+	assert.Equal(t, []string{"a.Before SayHello", "b.Before SayHello", "process SayHello",
+		"b.After SayHello <nil>", "a.After SayHello <nil>"}, calls)
+	assert.Empty(t, p.messages)
+	assert.Equal(t, int64(0), inFlight)
+
+	calls = calls[:0]
+	p = &testThriftProtocol{}
+	assert.True(t, service.serveCall(&ThriftCall{Method: "Panic", SeqID: 7}, thrift.CALL, p, p))
+	assert.Equal(t, []string{"a.Before Panic", "b.Before Panic", "process Panic",
+		"b.After Panic turbo: panic in thrift call Panic: boom", "a.After Panic turbo: panic in thrift call Panic: boom"}, calls)
+	assert.Equal(t, []thrift.TMessageType{thrift.EXCEPTION}, p.messages)
+
+	calls = calls[:0]
+	p = &testThriftProtocol{}
+	b.reject = errors.New("denied")
+	assert.True(t, service.serveCall(&ThriftCall{Method: "SayHello", SeqID: 7}, thrift.CALL, p, p))
+	assert.Equal(t, []string{"a.Before SayHello", "b.Before SayHello", "a.After SayHello denied"}, calls)
+	assert.True(t, p.skipped)
+	assert.Equal(t, []thrift.TMessageType{thrift.EXCEPTION}, p.messages)
+}
+
+func TestThriftServiceLimits(t *testing.T) {
+	c := NewConfigFromMap("thrift", map[string]string{httpPort: "8081", thriftMaxConnections: "1", thriftWorkers: "1",
+		thriftReadTimeout: "10ms"})
+	service := newThriftService(nil, nil, func() *Config { return c }, new(int64), nil)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	assert.True(t, service.track(c1))
+	c3, c4 := net.Pipe()
+	defer c4.Close()
+	assert.False(t, service.track(c3))
+	service.untrack(c1)
+	assert.True(t, service.track(c3))
+	service.closeConnections()
+
+	service.acquire()
+	acquired := make(chan bool)
+	go func() {
+		service.acquire()
+		acquired <- true
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("calls beyond [thrift_workers] should wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	service.release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("a waiting call should be processed once a worker is released")
+	}
+	service.release()
+
+	c5, c6 := net.Pipe()
+	defer c5.Close()
+	defer c6.Close()
+	conn := &readTimeoutConn{Conn: c5, timeout: c.ThriftReadTimeout()}
+	_, err := conn.Read(make([]byte, 1))
+	assert.True(t, err.(net.Error).Timeout())
+}
+
+func TestLoadThriftInterceptors(t *testing.T) {
+	s := &Server{Components: new(Components)}
+	calls := make([]string, 0)
+	s.RegisterThriftInterceptor("a", &testThriftInterceptor{name: "a", calls: &calls})
+	s.RegisterHijacker("hijacker", func(http.ResponseWriter, *http.Request) {})
+	list, err := s.thriftInterceptors(NewConfigFromSource("thrift", NewStaticSource("test", []byte(`config:
+  http_port: 8081
+thrift_interceptor: a
+`))))
+	assert.Nil(t, err)
+	assert.Len(t, list, 1)
+	_, err = s.thriftInterceptors(NewConfigFromSource("thrift", NewStaticSource("test", []byte(`config:
+  http_port: 8081
+thrift_interceptor: a,hijacker
+`))))
+	assert.Equal(t, "turbo: failed to load thrift interceptors, error: component [hijacker] is registered as [hijacker], "+
+		"can not be used as [thrift_interceptor]", err.Error())
+}
@@ -0,0 +1,314 @@
+package turbo
+
+import (
+	"fmt"
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ThriftCall is a thrift call processed by the thrift service
+type ThriftCall struct {
+	// Method is the name of the thrift method called
+	Method string
+	SeqID  int32
+	// Remote is the address of the client
+	Remote net.Addr
+	// Start is the time the call begins to be processed
+	Start time.Time
+}
+
+// ThriftInterceptor intercepts thrift calls processed by the thrift service, as Interceptor does for HTTP requests.
+// If Before() returns an error, the call is rejected with it, as a thrift.TApplicationException.
+// After() is called with the error of processing, if Before() of the same interceptor succeeded.
+type ThriftInterceptor interface {
+	Before(call *ThriftCall) error
+	After(call *ThriftCall, err error) error
+}
+
+// thriftService serves thrift calls on a listener, a connection is served by its own goroutine,
+// and the number of connections, and of calls processed at the same time are limited by config.
+type thriftService struct {
+	listener        net.Listener
+	processor       thrift.TProcessor
+	protocolFactory thrift.TProtocolFactory
+	// config returns the current config, limits in it are applied to new connections and calls
+	config func() *Config
+	// inFlight counts calls read, including those waiting for a worker
+	inFlight     *int64
+	interceptors atomic.Value
+
+	mu      sync.Mutex
+	idle    *sync.Cond
+	working int64
+	conns   map[net.Conn]bool
+	stopped bool
+}
+
+func newThriftService(lis net.Listener, processor thrift.TProcessor, config func() *Config, inFlight *int64,
+	interceptors []ThriftInterceptor) *thriftService {
+	t := &thriftService{
+		listener:        lis,
+		processor:       processor,
+		protocolFactory: thrift.NewTBinaryProtocolFactoryDefault(),
+		config:          config,
+		inFlight:        inFlight,
+		conns:           make(map[net.Conn]bool),
+	}
+	t.idle = sync.NewCond(&t.mu)
+	t.setInterceptors(interceptors)
+	return t
+}
+
+func (t *thriftService) setInterceptors(interceptors []ThriftInterceptor) {
+	t.interceptors.Store(interceptors)
+}
+
+// Serve accepts connections until Stop() is called
+func (t *thriftService) Serve() error {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			if t.isStopped() {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !t.track(conn) {
+			conn.Close()
+			continue
+		}
+		go t.serveConn(conn)
+	}
+}
+
+// Stop stops accepting connections, established connections are served until closeConnections() is called
+func (t *thriftService) Stop() {
+	t.mu.Lock()
+	t.stopped = true
+	t.mu.Unlock()
+	t.listener.Close()
+}
+
+// closeConnections closes all connections, calls being processed on them fail
+func (t *thriftService) closeConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for conn := range t.conns {
+		conn.Close()
+	}
+}
+
+func (t *thriftService) isStopped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stopped
+}
+
+// track adds conn to the connections served, it returns false if "thrift_max_connections" is reached
+func (t *thriftService) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if max := t.config().ThriftMaxConnections(); max > 0 && int64(len(t.conns)) >= max {
+		log.Warnf("turbo: thrift connection from %s is closed, [%s] %d is reached", conn.RemoteAddr(), thriftMaxConnections, max)
+		return false
+	}
+	t.conns[conn] = true
+	return true
+}
+
+func (t *thriftService) untrack(conn net.Conn) {
+	t.mu.Lock()
+	delete(t.conns, conn)
+	t.mu.Unlock()
+	conn.Close()
+}
+
+// acquire waits until the number of calls being processed is less than "thrift_workers"
+func (t *thriftService) acquire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for max := t.config().ThriftWorkers(); max > 0 && t.working >= max; max = t.config().ThriftWorkers() {
+		t.idle.Wait()
+	}
+	t.working++
+}
+
+// release wakes all waiting calls, more than one of them may proceed if "thrift_workers" is raised by a reload
+func (t *thriftService) release() {
+	t.mu.Lock()
+	t.working--
+	t.mu.Unlock()
+	t.idle.Broadcast()
+}
+
+// serveConn processes calls on conn one by one, until it's closed, a read times out, or a call fails
+func (t *thriftService) serveConn(conn net.Conn) {
+	defer t.untrack(conn)
+	socket := thrift.NewTSocketFromConnTimeout(&readTimeoutConn{Conn: conn, timeout: t.config().ThriftReadTimeout()}, 0)
+	trans, err := thrift.NewTTransportFactory().GetTransport(socket)
+	if err != nil {
+		log.Error("turbo: failed to serve thrift connection, error: ", err)
+		return
+	}
+	protocol := t.protocolFactory.GetProtocol(trans)
+	for {
+		name, typeID, seqID, err := protocol.ReadMessageBegin()
+		if err != nil {
+			// the client closed the connection, or it's idle for longer than "thrift_read_timeout"
+			return
+		}
+		call := &ThriftCall{Method: name, SeqID: seqID, Remote: conn.RemoteAddr()}
+		ok := t.serveCall(call, typeID, protocol, protocol)
+		if !ok {
+			return
+		}
+	}
+}
+
+func (t *thriftService) serveCall(call *ThriftCall, typeID thrift.TMessageType, in, out thrift.TProtocol) bool {
+	atomic.AddInt64(t.inFlight, 1)
+	defer atomic.AddInt64(t.inFlight, -1)
+	t.acquire()
+	defer t.release()
+	call.Start = time.Now()
+	return t.process(call, typeID, in, out)
+}
+
+// process runs interceptors around the processor, a panic is recovered, and replied with an exception
+func (t *thriftService) process(call *ThriftCall, typeID thrift.TMessageType, in, out thrift.TProtocol) (ok bool) {
+	interceptors, _ := t.interceptors.Load().([]ThriftInterceptor)
+	var err error
+	ran := 0
+	defer func() {
+		if e := recover(); e != nil {
+			log.Errorf("turbo: panic in thrift call %s: %v\n%s", call.Method, e, debug.Stack())
+			err = fmt.Errorf("turbo: panic in thrift call %s: %v", call.Method, e)
+			ok = writeThriftException(call, out,
+				thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "turbo: internal error in "+call.Method)) == nil
+		}
+		for i := ran - 1; i >= 0; i-- {
+			if e := interceptors[i].After(call, err); e != nil {
+				log.Errorln("turbo: error in thrift After(): ", e.Error())
+			}
+		}
+	}()
+	for _, i := range interceptors {
+		if err = i.Before(call); err != nil {
+			log.Errorln("turbo: thrift call rejected in Before(): ", err.Error())
+			return rejectThriftCall(call, in, out, err) == nil
+		}
+		ran++
+	}
+	ok, ex := t.processor.Process(&storedMessageProtocol{TProtocol: in, name: call.Method, typeID: typeID, seqID: call.SeqID}, out)
+	if ex != nil {
+		err = ex
+	}
+	return ok
+}
+
+// rejectThriftCall skips the arguments of call, and replies with err as an exception
+func rejectThriftCall(call *ThriftCall, in, out thrift.TProtocol, err error) error {
+	if e := in.Skip(thrift.STRUCT); e != nil {
+		return e
+	}
+	if e := in.ReadMessageEnd(); e != nil {
+		return e
+	}
+	ex, ok := err.(thrift.TApplicationException)
+	if !ok {
+		ex = thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION, err.Error())
+	}
+	return writeThriftException(call, out, ex)
+}
+
+func writeThriftException(call *ThriftCall, out thrift.TProtocol, ex thrift.TApplicationException) error {
+	if err := out.WriteMessageBegin(call.Method, thrift.EXCEPTION, call.SeqID); err != nil {
+		return err
+	}
+	if err := ex.Write(out); err != nil {
+		return err
+	}
+	if err := out.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// storedMessageProtocol returns the message header read already, so that the processor can read it again
+type storedMessageProtocol struct {
+	thrift.TProtocol
+	name   string
+	typeID thrift.TMessageType
+	seqID  int32
+}
+
+func (p *storedMessageProtocol) ReadMessageBegin() (string, thrift.TMessageType, int32, error) {
+	return p.name, p.typeID, p.seqID, nil
+}
+
+// readTimeoutConn fails a read if no data is received in timeout, 0 means no timeout
+type readTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *readTimeoutConn) Read(p []byte) (int, error) {
+	if c.timeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(p)
+}
+
+// ThriftInterceptors returns names in "thrift_interceptor", they run around all thrift calls processed
+// by the thrift service, in order.
+func (c *Config) ThriftInterceptors() []string {
+	names, err := parseNames(c.Get("thrift_interceptor"))
+	if err != nil {
+		panic("[thrift_interceptor] should be a list of names, got: " + fmt.Sprint(c.Get("thrift_interceptor")))
+	}
+	return names
+}
+
+// ThriftMaxConnections returns "thrift_max_connections" in config file, new connections to the thrift service
+// beyond it are closed, 0 means no limit.
+func (c *Config) ThriftMaxConnections() int64 {
+	return c.intValue(thriftMaxConnections)
+}
+
+// ThriftWorkers returns "thrift_workers" in config file, the max number of thrift calls processed at the same time,
+// calls beyond it wait for a call to complete, 0 means no limit.
+func (c *Config) ThriftWorkers() int64 {
+	return c.intValue(thriftWorkers)
+}
+
+// ThriftReadTimeout returns "thrift_read_timeout" in config file, e.g. "30s", a connection to the thrift service
+// is closed if no data is read from it in this time, including idle time between calls, 0 means no timeout.
+func (c *Config) ThriftReadTimeout() time.Duration {
+	return c.durationValue(thriftReadTimeout, 0)
+}
+
+// thriftInterceptors returns the ThriftInterceptors named in "thrift_interceptor" of c
+func (s *Server) thriftInterceptors(c *Config) (list []ThriftInterceptor, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("turbo: failed to load thrift interceptors, error: %v", e)
+		}
+	}()
+	components := &Components{registeredComponents: s.currentComponents().registeredComponents}
+	list = make([]ThriftInterceptor, 0)
+	for _, name := range c.ThriftInterceptors() {
+		list = append(list, getComponentByName(components, strings.TrimSpace(name), kindThriftInterceptor).(ThriftInterceptor))
+	}
+	return list, nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/vaporz/turbo"
+)
+
+var validateCmd = &cobra.Command{
+	Use:     "validate package_path",
+	Aliases: []string{"v"},
+	Example: "turbo validate package/path/to/yourservice -r grpc -m components.yaml",
+	Short: "Validate service.yaml, checks syntax, duplicate and unreachable routes,\n" +
+		"method names against generated codes, and component names against a manifest",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Usage: validate [package_path] -r [grpc|thrift] -c [config_file] -m [manifest_file]")
+		}
+		if ValidateRpcType != "grpc" && ValidateRpcType != "thrift" {
+			return errors.New("invalid rpctype")
+		}
+		configFile := ConfigFile
+		if len(configFile) == 0 {
+			configFile = turbo.GOPATH() + "/src/" + args[0] + "/service.yaml"
+		}
+		v := turbo.Validator{
+			RpcType:    ValidateRpcType,
+			ConfigFile: configFile,
+			Manifest:   Manifest,
+		}
+		// problems are printed as diagnostics, usage is not helpful here
+		cmd.SilenceUsage, cmd.SilenceErrors = true, true
+		diagnostics := v.Validate()
+		for _, d := range diagnostics {
+			fmt.Println(d)
+		}
+		if len(diagnostics) > 0 {
+			return fmt.Errorf("%d problem(s) found in %s", len(diagnostics), configFile)
+		}
+		fmt.Println(configFile + " is valid")
+		return nil
+	},
+}
+
+// ValidateRpcType should be either "grpc" or "thrift"
+var ValidateRpcType string
+
+// ConfigFile is the path to the config file, defaults to "service.yaml" in package_path
+var ConfigFile string
+
+// Manifest is the path to a yaml file which lists registered component names by kind
+var Manifest string
+
+func init() {
+	RootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().StringVarP(&ValidateRpcType, "rpctype", "r", "", "required, (grpc|thrift)")
+	validateCmd.Flags().StringVarP(&ConfigFile, "config", "c", "", "path to the config file, defaults to [package_path]/service.yaml")
+	validateCmd.Flags().StringVarP(&Manifest, "manifest", "m", "", "path to the component manifest, component names are not checked if not set")
+}
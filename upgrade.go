@@ -0,0 +1,258 @@
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// listenFDsStart is the first file descriptor passed by systemd socket activation, or a graceful restart
+	listenFDsStart = 3
+	// readyFDEnv holds the file descriptor a new process writes to once it's ready in a graceful restart,
+	// it has no "TURBO_" prefix, which is for values in config.
+	readyFDEnv = "UPGRADE_READY_FD"
+)
+
+// inherited holds listeners passed by systemd socket activation, or the process before a graceful restart,
+// they are taken by listen() with the same address.
+var inherited = struct {
+	sync.Mutex
+	once      sync.Once
+	listeners []net.Listener
+}{}
+
+// active holds listeners opened by listen(), which are passed to the new process in a graceful restart
+var active = struct {
+	sync.Mutex
+	listeners map[*trackedListener]bool
+}{listeners: make(map[*trackedListener]bool)}
+
+// trackedListener is removed from active listeners when it's closed
+type trackedListener struct {
+	net.Listener
+}
+
+func (l *trackedListener) Close() error {
+	active.Lock()
+	delete(active.listeners, l)
+	active.Unlock()
+	return l.Listener.Close()
+}
+
+func track(lis net.Listener) net.Listener {
+	l := &trackedListener{Listener: lis}
+	active.Lock()
+	active.listeners[l] = true
+	active.Unlock()
+	return l
+}
+
+// listenFDs returns the number of listening sockets passed to this process in "LISTEN_FDS",
+// "LISTEN_PID" is checked if it's set, as systemd does, the variables are unset so that child processes don't use them.
+func listenFDs() int {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid := os.Getenv("LISTEN_PID"); len(pid) > 0 && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// loadInherited loads listening sockets passed to this process once
+func loadInherited() {
+	inherited.once.Do(func() {
+		files := make([]*os.File, 0)
+		for i := 0; i < listenFDs(); i++ {
+			files = append(files, os.NewFile(uintptr(listenFDsStart+i), "LISTEN_FD_"+strconv.Itoa(i)))
+		}
+		inherit(files)
+	})
+}
+
+// inherit adds the listening sockets in files to inherited listeners
+func inherit(files []*os.File) {
+	inherited.Lock()
+	defer inherited.Unlock()
+	for _, f := range files {
+		lis, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			log.Error("turbo: failed to inherit listener, error: ", err)
+			continue
+		}
+		log.Info("inherited listener: ", lis.Addr())
+		inherited.listeners = append(inherited.listeners, lis)
+	}
+}
+
+// takeInherited returns the inherited listener on address of network, or nil if there's none
+func takeInherited(network, address string) net.Listener {
+	loadInherited()
+	inherited.Lock()
+	defer inherited.Unlock()
+	for i, lis := range inherited.listeners {
+		if sameAddr(network, address, lis.Addr()) {
+			inherited.listeners = append(inherited.listeners[:i], inherited.listeners[i+1:]...)
+			return lis
+		}
+	}
+	return nil
+}
+
+// sameAddr tells whether the address listened on is the same as addr, e.g. ":8081" is the same as "[::]:8081"
+func sameAddr(network, address string, addr net.Addr) bool {
+	if network != addr.Network() {
+		return false
+	}
+	if network == "unix" {
+		return address == addr.String()
+	}
+	want, err := net.ResolveTCPAddr(network, address)
+	got, ok := addr.(*net.TCPAddr)
+	if err != nil || !ok || want.Port == 0 || want.Port != got.Port {
+		return false
+	}
+	if len(want.IP) == 0 || want.IP.IsUnspecified() {
+		return got.IP.IsUnspecified()
+	}
+	return want.IP.Equal(got.IP)
+}
+
+// listenerFiles returns the files of active listeners, sorted by address
+func listenerFiles() ([]*os.File, error) {
+	active.Lock()
+	listeners := make([]net.Listener, 0, len(active.listeners))
+	for l := range active.listeners {
+		listeners = append(listeners, l.Listener)
+	}
+	active.Unlock()
+	sort.Slice(listeners, func(i, j int) bool { return listeners[i].Addr().String() < listeners[j].Addr().String() })
+	files := make([]*os.File, 0, len(listeners))
+	for _, lis := range listeners {
+		filer, ok := lis.(interface{ File() (*os.File, error) })
+		if !ok {
+			closeFiles(files)
+			return nil, fmt.Errorf("turbo: listener on %s can not be passed to a new process", lis.Addr())
+		}
+		f, err := filer.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// upgrade starts a new process of the same executable and arguments, which inherits the listening sockets
+// of this process, it returns once the new process is ready to serve, or fails to start in timeout.
+// This process should be shut down gracefully if it returns nil.
+func upgrade(timeout time.Duration) error {
+	files, err := listenerFiles()
+	if err != nil {
+		return err
+	}
+	defer closeFiles(files)
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(upgradeEnv(os.Environ()), "LISTEN_FDS="+strconv.Itoa(len(files)),
+		readyFDEnv+"="+strconv.Itoa(listenFDsStart+len(files)))
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	log.Infof("Started new process %d with %d listeners, waiting for it to be ready...", cmd.Process.Pid, len(files))
+	go cmd.Wait()
+	ready := make(chan error, 1)
+	go func() {
+		// it's EOF if the new process exits before it's ready
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+		if err != nil {
+			return fmt.Errorf("turbo: new process %d exited before it's ready, error: %v", cmd.Process.Pid, err)
+		}
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("turbo: new process %d is not ready in %s, killed", cmd.Process.Pid, timeout)
+	}
+	// the socket files are used by the new process now
+	active.Lock()
+	for l := range active.listeners {
+		if ul, ok := l.Listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	active.Unlock()
+	return nil
+}
+
+// upgradeEnv returns env without variables of socket activation, which are set for the new process
+func upgradeEnv(env []string) []string {
+	result := make([]string, 0, len(env))
+	for _, kv := range env {
+		name := kv[:strings.Index(kv+"=", "=")]
+		if name == "LISTEN_PID" || name == "LISTEN_FDS" || name == "LISTEN_FDNAMES" || name == readyFDEnv {
+			continue
+		}
+		result = append(result, kv)
+	}
+	return result
+}
+
+// notifyReady tells the process before a graceful restart that this process is ready, if there's one
+func notifyReady() error {
+	v := os.Getenv(readyFDEnv)
+	if len(v) == 0 {
+		return nil
+	}
+	os.Unsetenv(readyFDEnv)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return errors.New("turbo: invalid " + readyFDEnv + ": " + v)
+	}
+	f := os.NewFile(uintptr(fd), readyFDEnv)
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
+
+// UpgradeTimeout returns "upgrade_timeout" in config file, defaults to "1m",
+// it's the max time to wait for the new process to be ready in a graceful restart triggered by SIGUSR2.
+func (c *Config) UpgradeTimeout() time.Duration {
+	return c.durationValue(upgradeTimeout, time.Minute)
+}
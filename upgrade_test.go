@@ -0,0 +1,82 @@
+package turbo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestInheritListeners(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := raw.Addr().String()
+	f, err := raw.(*net.TCPListener).File()
+	assert.Nil(t, err)
+	raw.Close()
+	loadInherited()
+	inherit([]*os.File{f})
+
+	lis, err := listen(addr, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, addr, lis.Addr().String())
+	go func() {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := lis.Accept()
+	assert.Nil(t, err)
+	conn.Close()
+
+	files, err := listenerFiles()
+	assert.Nil(t, err)
+	assert.Len(t, files, len(active.listeners))
+	closeFiles(files)
+	assert.True(t, active.listeners[lis.(*trackedListener)])
+	lis.Close()
+	assert.False(t, active.listeners[lis.(*trackedListener)])
+}
+
+func TestSameAddr(t *testing.T) {
+	unspecified := &net.TCPAddr{IP: net.IPv6unspecified, Port: 8081}
+	assert.True(t, sameAddr("tcp", ":8081", unspecified))
+	assert.True(t, sameAddr("tcp", "0.0.0.0:8081", unspecified))
+	assert.False(t, sameAddr("tcp", "127.0.0.1:8081", unspecified))
+	assert.False(t, sameAddr("tcp", ":8082", unspecified))
+	assert.False(t, sameAddr("tcp", ":0", &net.TCPAddr{IP: net.IPv6unspecified}))
+	assert.True(t, sameAddr("tcp", "127.0.0.1:8081", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8081}))
+	assert.True(t, sameAddr("unix", "/var/run/turbo.sock", &net.UnixAddr{Net: "unix", Name: "/var/run/turbo.sock"}))
+	assert.False(t, sameAddr("tcp", ":8081", &net.UnixAddr{Net: "unix", Name: "/var/run/turbo.sock"}))
+}
+
+func TestListenFDs(t *testing.T) {
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "2")
+	assert.Equal(t, 0, listenFDs())
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "2")
+	assert.Equal(t, 2, listenFDs())
+	_, ok := os.LookupEnv("LISTEN_FDS")
+	assert.False(t, ok)
+	os.Setenv("LISTEN_FDS", "1")
+	assert.Equal(t, 1, listenFDs())
+
+	assert.Equal(t, []string{"PATH=/bin", "LISTEN_ADDRESS=:8081"},
+		upgradeEnv([]string{"PATH=/bin", "LISTEN_FDS=2", "LISTEN_PID=10", "LISTEN_ADDRESS=:8081", readyFDEnv + "=5"}))
+}
+
+func TestNotifyReady(t *testing.T) {
+	assert.Nil(t, notifyReady())
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	defer r.Close()
+	os.Setenv(readyFDEnv, strconv.Itoa(int(w.Fd())))
+	assert.Nil(t, notifyReady())
+	n, err := r.Read(make([]byte, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, ok := os.LookupEnv(readyFDEnv)
+	assert.False(t, ok)
+}
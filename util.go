@@ -59,8 +59,9 @@ func ToSnakeCase(str string) string {
 // 1, run http.Request.ParseForm()
 // 2, find keys with upper case characters, and append their values to a lower case key
 // 3, merge route variables, route variables will come at the first place
-func parseRequestForm(req *http.Request) {
-	req.ParseForm()
+// the error of ParseForm() is returned, e.g. errBodyTooLarge
+func parseRequestForm(req *http.Request) error {
+	err := req.ParseForm()
 	// Should param keys be case-sensitive?
 	// Maybe no, "be liberal in what you accept and conservative in what you send".
 	// So, case-insensitive.
@@ -68,6 +69,7 @@ func parseRequestForm(req *http.Request) {
 	// https://stackoverflow.com/questions/7996919/should-url-be-case-sensitive
 	mergeUpperCaseKeysToLowerCase(req)
 	mergeMuxVars(req)
+	return err
 }
 
 func mergeUpperCaseKeysToLowerCase(req *http.Request) {
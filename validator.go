@@ -0,0 +1,326 @@
+package turbo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Diagnostic is a problem found in config by Validator
+type Diagnostic struct {
+	// Position is where the problem is, e.g. "service.yaml:12", it's empty if unknown
+	Position string
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	if len(d.Position) == 0 {
+		return d.Message
+	}
+	return d.Position + ": " + d.Message
+}
+
+// Validator checks a config file without starting a server
+type Validator struct {
+	RpcType    string
+	ConfigFile string
+	// Methods are the rpc method names of the service,
+	// they are read from generated codes in "[service_root_path]/gen" if nil.
+	Methods []string
+	// Manifest is the path to a yaml file which lists the names of registered components by kind, e.g.
+	//	interceptor: [LogInterceptor]
+	//	preprocessor: [checkName]
+	//	postprocessor: [setName]
+	//	transformer: [addTimestamp]
+	//	hijacker: []
+	//	convertor: [convertCommonValues]
+	//	errorhandler: [errorHandler]
+	// component names are not checked if it's empty.
+	Manifest string
+}
+
+// lineKeys maps mapping kinds to their keys in config file
+var lineKeys = map[string]string{
+	urlServiceMaps: "urlmapping",
+	interceptors:   "interceptor",
+	preprocessors:  "preprocessor",
+	postprocessors: "postprocessor",
+	hijackers:      "hijacker",
+	convertors:     "convertor",
+}
+
+// Validate returns the problems found, the config is valid if nothing is returned
+func (v *Validator) Validate() []Diagnostic {
+	c, err := loadConfigNoPanic(v.RpcType, v.ConfigFile)
+	if err != nil {
+		return []Diagnostic{{Position: v.ConfigFile, Message: err.Error()}}
+	}
+	diagnostics := v.checkRoutes(c)
+	diagnostics = append(diagnostics, v.checkMethods(c)...)
+	return append(diagnostics, v.checkComponents(c)...)
+}
+
+func loadConfigNoPanic(rpcType, file string) (c *Config, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	return NewConfig(rpcType, file), nil
+}
+
+type checkedRoute struct {
+	methods []string
+	path    string
+	pos     string
+	route   *mux.Route
+}
+
+// checkRoutes checks invalid paths, duplicate routes, and routes which are unreachable
+// because a former route with the same method matches first.
+func (v *Validator) checkRoutes(c *Config) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0)
+	checked := make([]checkedRoute, 0)
+	for i, m := range c.mappings[urlServiceMaps] {
+		pos := c.mappingPosition(urlServiceMaps, i, m)
+		methods := strings.Split(m[0], ",")
+		route := mux.NewRouter().NewRoute().Path(m[1]).Methods(methods...)
+		if err := route.GetError(); err != nil {
+			diagnostics = append(diagnostics, Diagnostic{pos, fmt.Sprintf("invalid path %s, error: %s", m[1], err)})
+			continue
+		}
+		for _, prev := range checked {
+			overlap := commonMethods(prev.methods, methods)
+			if len(overlap) == 0 {
+				continue
+			}
+			if normalizePath(prev.path) == normalizePath(m[1]) {
+				diagnostics = append(diagnostics, Diagnostic{pos,
+					fmt.Sprintf("duplicate route %s %s, it's already mapped at %s", strings.Join(overlap, ","), m[1], prev.pos)})
+				break
+			}
+			if !strings.Contains(m[1], "{") && matches(prev.route, overlap[0], m[1]) {
+				diagnostics = append(diagnostics, Diagnostic{pos,
+					fmt.Sprintf("unreachable route %s %s, %s at %s matches it first", strings.Join(overlap, ","), m[1], prev.path, prev.pos)})
+				break
+			}
+		}
+		checked = append(checked, checkedRoute{methods: methods, path: m[1], pos: pos, route: route})
+	}
+	return diagnostics
+}
+
+// mappingPosition returns the position of mapping m of kind, m is either a line, or an entry in "routes"
+func (c *Config) mappingPosition(kind string, index int, m [3]string) string {
+	pos := c.find(lineKeys[kind], func(line string) bool {
+		f := strings.Fields(line)
+		return len(f) == 3 && f[0] == m[0] && f[1] == m[1] && f[2] == m[2]
+	})
+	if len(pos) > 0 {
+		return pos
+	}
+	return c.find(routesKey, func(line string) bool { return line == "path: "+m[1] })
+}
+
+func commonMethods(a, b []string) []string {
+	result := make([]string, 0)
+	for _, m := range b {
+		if contains(a, m) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+func matches(route *mux.Route, method, path string) bool {
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		return false
+	}
+	return route.Match(req, &mux.RouteMatch{})
+}
+
+// normalizePath removes variable names in path, e.g. "/user/{id:[0-9]+}" becomes "/user/{:[0-9]+}",
+// so that paths which match the same urls are equal.
+func normalizePath(path string) string {
+	var b bytes.Buffer
+	depth, naming := 0, false
+	for _, r := range path {
+		switch {
+		case r == '{':
+			depth++
+			if depth == 1 {
+				naming = true
+				b.WriteRune(r)
+				continue
+			}
+		case r == '}':
+			depth--
+			if depth == 0 {
+				naming = false
+			}
+		case r == ':' && depth == 1:
+			naming = false
+		}
+		if !naming {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// checkMethods checks rpc method names in urlmapping against the generated codes
+func (v *Validator) checkMethods(c *Config) []Diagnostic {
+	methods := v.Methods
+	if methods == nil {
+		var err error
+		if methods, err = stubMethods(c); err != nil {
+			return []Diagnostic{{Message: err.Error()}}
+		}
+	}
+	diagnostics := make([]Diagnostic, 0)
+	for i, m := range c.mappings[urlServiceMaps] {
+		if !contains(methods, m[2]) {
+			diagnostics = append(diagnostics, Diagnostic{c.mappingPosition(urlServiceMaps, i, m),
+				fmt.Sprintf("no such method [%s] in service, valid methods are: %s", m[2], strings.Join(methods, ", "))})
+		}
+	}
+	return diagnostics
+}
+
+// stubMethods returns the method names of the service interface in generated codes,
+// the interface is "[grpc_service_name]Client" for grpc, and "[thrift_service_name]" for thrift.
+func stubMethods(c *Config) (methods []string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	name := c.ThriftServiceName()
+	if c.RpcType() == "grpc" {
+		name = c.GrpcServiceName() + "Client"
+	}
+	dir := c.ServiceRootPathAbsolute() + "/gen"
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") || methods != nil {
+			return err
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			spec, ok := n.(*ast.TypeSpec)
+			if !ok || spec.Name.Name != name {
+				return true
+			}
+			if iface, ok := spec.Type.(*ast.InterfaceType); ok {
+				methods = make([]string, 0)
+				for _, m := range iface.Methods.List {
+					for _, n := range m.Names {
+						methods = append(methods, n.Name)
+					}
+				}
+			}
+			return false
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if methods == nil {
+		return nil, errors.New("interface " + name + " is not found in " + dir + ", run 'turbo generate' first")
+	}
+	return methods, nil
+}
+
+var manifestKinds = []string{"interceptor", "preprocessor", "postprocessor", "transformer", "hijacker", "convertor", "errorhandler", "policy", "middleware",
+	"thrift_interceptor"}
+
+// checkComponents checks component names in config against the manifest
+func (v *Validator) checkComponents(c *Config) []Diagnostic {
+	if len(v.Manifest) == 0 {
+		return nil
+	}
+	manifest := viper.New()
+	manifest.SetConfigFile(v.Manifest)
+	if err := manifest.ReadInConfig(); err != nil {
+		return []Diagnostic{{Position: v.Manifest, Message: err.Error()}}
+	}
+	registered := make(map[string][]string)
+	for _, kind := range manifestKinds {
+		registered[kind] = manifest.GetStringSlice(kind)
+	}
+	registered["interceptor"] = append(registered["interceptor"], JWTAuthName, APIKeyAuthName, BasicAuthName)
+	diagnostics := make([]Diagnostic, 0)
+	check := func(pos, kind, name string) {
+		kinds := []string{kind}
+		if kind == "postprocessor" {
+			// a postprocessor chain is made of transformers, and optionally a postprocessor
+			kinds = []string{"transformer", "postprocessor"}
+		}
+		if kind == "interceptor" {
+			// middlewares are used as interceptors
+			kinds = []string{"interceptor", "middleware"}
+		}
+		for _, k := range kinds {
+			if contains(registered[k], name) {
+				return
+			}
+		}
+		msg := fmt.Sprintf("no such %s [%s] in manifest %s", kind, name, v.Manifest)
+		for _, other := range manifestKinds {
+			if contains(registered[other], name) {
+				msg = fmt.Sprintf("[%s] is registered as [%s], not [%s]", name, other, strings.Join(kinds, "] or ["))
+				break
+			}
+		}
+		diagnostics = append(diagnostics, Diagnostic{pos, msg})
+	}
+	for _, name := range c.GlobalInterceptors() {
+		check(c.find("global_interceptor", func(line string) bool { return strings.Contains(line, name) }), "interceptor", name)
+	}
+	for _, name := range c.ThriftInterceptors() {
+		check(c.find("thrift_interceptor", func(line string) bool { return strings.Contains(line, name) }), "thrift_interceptor", name)
+	}
+	for _, kind := range []string{interceptors, preprocessors, postprocessors, hijackers} {
+		for i, m := range c.mappings[kind] {
+			for _, name := range strings.Split(m[2], ",") {
+				// "!Name" excludes an interceptor
+				check(c.mappingPosition(kind, i, m), lineKeys[kind], strings.TrimPrefix(name, "!"))
+			}
+		}
+	}
+	for _, m := range c.mappings[convertors] {
+		pos := c.find(lineKeys[convertors], func(line string) bool {
+			f := strings.Fields(line)
+			return len(f) == 2 && f[0] == m[0] && f[1] == m[1]
+		})
+		check(pos, "convertor", m[1])
+	}
+	for _, r := range c.authz {
+		if len(r.Policy) > 0 {
+			name := r.Policy
+			match := func(line string) bool { return line == "policy: "+name }
+			pos := c.find(authzKey, match)
+			if len(pos) == 0 {
+				pos = c.find(routesKey, match)
+			}
+			check(pos, "policy", name)
+		}
+	}
+	if len(c.ErrorHandler()) > 0 {
+		check(c.find("errorhandler", func(string) bool { return true }), "errorhandler", c.ErrorHandler())
+	}
+	return diagnostics
+}
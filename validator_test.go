@@ -0,0 +1,108 @@
+package turbo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const validatorStub = `package proto
+
+import "context"
+
+type YourServiceClient interface {
+	SayHello(ctx context.Context, in *SayHelloRequest) (*SayHelloResponse, error)
+	EatApple(ctx context.Context, in *EatAppleRequest) (*EatAppleResponse, error)
+}
+`
+
+const validatorManifest = `interceptor: [LogInterceptor]
+preprocessor: [preprocessor]
+hijacker: [hijacker]
+convertor: [convertor]
+errorhandler: [error_handler]
+`
+
+func setupValidator(t *testing.T, config string) (*Validator, func()) {
+	dir, err := ioutil.TempDir("", "turbo_validate")
+	assert.Nil(t, err)
+	assert.Nil(t, os.MkdirAll(dir+"/gen/proto", 0755))
+	assert.Nil(t, ioutil.WriteFile(dir+"/gen/proto/service.pb.go", []byte(validatorStub), 0644))
+	assert.Nil(t, ioutil.WriteFile(dir+"/components.yaml", []byte(validatorManifest), 0644))
+	config = "config:\n  service_root_path: " + dir + "\n  http_port: 8081\n  grpc_service_name: YourService\n" + config
+	assert.Nil(t, ioutil.WriteFile(dir+"/service.yaml", []byte(config), 0644))
+	v := &Validator{RpcType: "grpc", ConfigFile: dir + "/service.yaml", Manifest: dir + "/components.yaml"}
+	return v, func() { os.RemoveAll(dir) }
+}
+
+func diagnosticStrings(v *Validator) []string {
+	result := make([]string, 0)
+	for _, d := range v.Validate() {
+		result = append(result, d.String())
+	}
+	return result
+}
+
+func TestValidate(t *testing.T) {
+	v, remove := setupValidator(t, `urlmapping:
+  - GET,POST /hello SayHello
+  - GET /apple/{num:[0-9]+} EatApple
+interceptor:
+  - GET,POST /hello LogInterceptor
+preprocessor:
+  - GET,POST /hello preprocessor
+hijacker:
+  - GET /apple/{num:[0-9]+} hijacker
+convertor:
+  - CommonValues convertor
+errorhandler: error_handler
+`)
+	defer remove()
+	assert.Equal(t, []string{}, diagnosticStrings(v))
+}
+
+func TestValidateProblems(t *testing.T) {
+	v, remove := setupValidator(t, `urlmapping:
+  - GET,POST /hello SayHello
+  - GET /apple/{num:[0-9]+} EatApple
+  - POST /hello SayHello
+  - GET /apple/{id:[0-9]+} EatApple
+  - GET /apple/1 EatApple
+  - GET /banana EatBanana
+interceptor:
+  - GET,POST /hello hijacker
+preprocessor:
+  - GET,POST /hello checkName
+routes:
+  - method: GET
+    path: /orange
+    rpc: EatOrange
+errorhandler: error_handler
+`)
+	defer remove()
+	file := v.ConfigFile
+	assert.Equal(t, []string{
+		file + ":8: duplicate route POST /hello, it's already mapped at " + file + ":6",
+		file + ":9: duplicate route GET /apple/{id:[0-9]+}, it's already mapped at " + file + ":7",
+		file + ":10: unreachable route GET /apple/1, /apple/{num:[0-9]+} at " + file + ":7 matches it first",
+		file + ":11: no such method [EatBanana] in service, valid methods are: SayHello, EatApple",
+		file + ":18: no such method [EatOrange] in service, valid methods are: SayHello, EatApple",
+		file + ":13: [hijacker] is registered as [hijacker], not [interceptor] or [middleware]",
+		file + ":15: no such preprocessor [checkName] in manifest " + v.Manifest,
+	}, diagnosticStrings(v))
+}
+
+func TestValidateSyntaxError(t *testing.T) {
+	v, remove := setupValidator(t, "urlmapping:\n  - GET /hello\n")
+	defer remove()
+	assert.Equal(t, []string{v.ConfigFile + ": " + v.ConfigFile + `:6: invalid [urlmapping] "GET /hello", should be "METHODS PATH NAME"`},
+		diagnosticStrings(v))
+
+	v.ConfigFile = "no_such_file.yaml"
+	assert.Equal(t, 1, len(v.Validate()))
+}
+
+func TestNormalizePath(t *testing.T) {
+	assert.Equal(t, "/user/{}/{:[0-9]{3}}", normalizePath("/user/{name}/{id:[0-9]{3}}"))
+}